@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"proj3/execution"
+	"proj3/nbody"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* cost of one step averaged over a measurement */
+type result struct {
+	perStep time.Duration
+	phases  execution.PhaseTimes
+	allocs  uint64
+	bytes   uint64
+	nodes   int /* arena slots the tree of the last step took, 0 without an arena */
+}
+
+/* run iterations of one executor on a fresh copy of the initial particles, reordered along cfg.ReorderCurve every cfg.ReorderEvery steps */
+func runSteps(execType string, cfg *nbody.SimulationConfig, initial []nbody.Particle, nIterations int, nThreads int, useArena bool, usePool bool) result {
+	particleArray := make([]nbody.Particle, len(initial))
+	copy(particleArray, initial)
+	integrator := nbody.EulerIntegrator{}
+	var arena *nbody.NodeArena
+	if useArena {
+		arena = nbody.NewNodeArena()
+	}
+
+	var phases execution.PhaseTimes
+	var executor execution.Executor
+	if usePool { /* without the pool every step gets its own executor, with no idle workers left from this one */
+		var err error
+		if executor, err = execution.NewExecutor(execType, cfg, integrator, nil, nThreads); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer executor.Close()
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	startTime := time.Now()
+	for iter := 0; iter < nIterations; iter++ {
+		if cfg.ReorderEvery > 0 && iter%cfg.ReorderEvery == 0 {
+			nbody.ReorderParticles(particleArray, cfg, nThreads)
+		}
+		min_limit, max_limit := nbody.GetBounds(particleArray)
+		var root *nbody.TreeNode
+		if useArena {
+			root = arena.InitRoot(min_limit, max_limit, cfg)
+		} else {
+			root = nbody.InitRoot(min_limit, max_limit, cfg)
+		}
+		if usePool {
+			executor.Step(root, particleArray)
+			continue
+		}
+		oneShot, err := execution.NewExecutor(execType, cfg, integrator, nil, nThreads)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		oneShot.Step(root, particleArray)
+		oneShot.Close()
+		stats := oneShot.Stats()
+		phases.Merge(&stats)
+	}
+	elapsed := time.Since(startTime)
+	runtime.ReadMemStats(&after)
+	if usePool {
+		phases = executor.Stats()
+	}
+	nodes := 0
+	if useArena {
+		nodes = arena.Len()
+	}
+
+	steps := uint64(nIterations)
+	return result{
+		perStep: elapsed / time.Duration(nIterations),
+		phases:  phases,
+		allocs:  (after.Mallocs - before.Mallocs) / steps,
+		bytes:   (after.TotalAlloc - before.TotalAlloc) / steps,
+		nodes:   nodes,
+	}
+}
+
+func parseInts(list string) []int {
+	var values []int
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid number %q in %q\n", field, list)
+			os.Exit(1)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func main() {
+	nParticles := flag.Int("n", 20000, "number of particles")
+	nIterations := flag.Int("iters", 10, "iterations per measurement")
+	nThreads := flag.Int("threads", 4, "goroutines for the parallel and work stealing executors")
+	execTypes := flag.String("exec", "s,p,w", "executors to measure, by registered name")
+	leafSizes := flag.String("leaf-sizes", "1,8,16,32", "leaf sizes to compare")
+	arenaModes := flag.String("arena", "off,on", "tree node allocation to compare: off (heap) and/or on (reused arena)")
+	dim := flag.Int("dim", 2, "spatial dimension")
+	pool := flag.Bool("pool", true, "keep the worker goroutines of the parallel executors alive across steps instead of starting them every step")
+	partition := flag.String("partition", nbody.PartitionStatic, "force work split of executor p: static or costzones")
+	curves := flag.String("curves", "none", "particle orders to compare: none (initial random order), morton and/or hilbert")
+	reorderEvery := flag.Int("reorder-every", 1, "steps between reorderings along the curve")
+	builder := flag.String("builder", nbody.BuilderInsert, "tree construction: insert or morton")
+	flag.Parse()
+	if *nIterations < 1 {
+		fmt.Fprintf(os.Stderr, "iterations per measurement must be positive, got %d\n", *nIterations)
+		os.Exit(1)
+	}
+
+	cfg := nbody.DefaultConfig()
+	cfg.Dim = *dim
+	cfg.TreeBuilder = *builder
+	cfg.Partition = *partition
+	initial := nbody.CreateParticleArray(*nParticles, cfg.Dim)
+
+	fmt.Printf("%d particles, %d iterations, %d threads, %s builder\n", *nParticles, *nIterations, *nThreads, cfg.TreeBuilder)
+	fmt.Printf("%-6s %-10s %-6s %-8s %-14s %-10s %-14s %-14s %-14s %-14s %-10s %s\n", "exec", "leaf size", "arena", "curve", "time/step", "speedup",
+		"build/step", "com/step", "force/step", "allocs/step", "KB/step", "arena nodes")
+	for _, execType := range strings.Split(*execTypes, ",") {
+		var baseline time.Duration
+		for _, leafSize := range parseInts(*leafSizes) {
+			cfg.LeafSize = leafSize
+			for _, arenaMode := range strings.Split(*arenaModes, ",") {
+				for _, curve := range strings.Split(*curves, ",") {
+					cfg.ReorderEvery, cfg.ReorderCurve = 0, nbody.CurveMorton
+					if curve != "none" {
+						cfg.ReorderEvery, cfg.ReorderCurve = *reorderEvery, curve
+					}
+					if err := cfg.Validate(); err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+					r := runSteps(execType, cfg, initial, *nIterations, *nThreads, arenaMode == "on", *pool)
+					if baseline == 0 {
+						baseline = r.perStep
+					}
+					steps := time.Duration(r.phases.Steps)
+					nodes := "-"
+					if r.nodes > 0 {
+						nodes = strconv.Itoa(r.nodes)
+					}
+					fmt.Printf("%-6s %-10d %-6s %-8s %-14s %-10s %-14s %-14s %-14s %-14d %-10d %s\n", execType, leafSize, arenaMode, curve,
+						r.perStep.Round(time.Microsecond), fmt.Sprintf("%.2fx", float64(baseline)/float64(r.perStep)),
+						(r.phases.Build / steps).Round(time.Microsecond), (r.phases.CenterOfMass / steps).Round(time.Microsecond),
+						(r.phases.Force / steps).Round(time.Microsecond), r.allocs, r.bytes/1024, nodes)
+				}
+			}
+		}
+	}
+}
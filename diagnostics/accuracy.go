@@ -0,0 +1,75 @@
+package diagnostics
+
+import (
+	"fmt"
+	"math"
+	"proj3/execution"
+	"proj3/nbody"
+	"sort"
+)
+
+/* distribution of per-particle relative acceleration errors of the tree against direct summation */
+type AccuracyReport struct {
+	Particles int
+	Theta     float64
+	Median    float64
+	P99       float64
+	Max       float64
+}
+
+func (r AccuracyReport) String() string {
+	return fmt.Sprintf("Accuracy for %d particles at theta=%g: relative acceleration error median %.3e, 99th percentile %.3e, max %.3e",
+		r.Particles, r.Theta, r.Median, r.P99, r.Max)
+}
+
+/* compute tree and direct accelerations on copies of the same snapshot and compare them */
+func MeasureAccuracy(particleArray []nbody.Particle, cfg *nbody.SimulationConfig, nThreads int) AccuracyReport {
+	tree := make([]nbody.Particle, len(particleArray))
+	copy(tree, particleArray)
+	min_limit, max_limit := nbody.GetBounds(tree)
+	root := nbody.InitRoot(min_limit, max_limit, cfg)
+	for i := range tree {
+		nbody.TreeInsert(root, &tree[i], false)
+	}
+	nbody.PopulateCenterOfMass(root)
+	nbody.TraverseTree(root, root)
+	if _, ok := cfg.MAC.(nbody.RelativeMAC); ok {
+		nbody.TraverseTree(root, root) /* the first walk only provides the previous acceleration the criterion needs */
+	}
+
+	direct := make([]nbody.Particle, len(particleArray))
+	copy(direct, particleArray)
+	execution.ComputeDirectForces(direct, cfg, nThreads)
+
+	errors := make([]float64, len(particleArray))
+	for i := range particleArray {
+		tx, ty, tz := tree[i].Acceleration()
+		dx, dy, dz := direct[i].Acceleration()
+		exact := norm(dx, dy, dz)
+		errors[i] = norm(tx-dx, ty-dy, tz-dz)
+		if exact != 0 {
+			errors[i] /= exact
+		}
+	}
+	sort.Float64s(errors)
+
+	return AccuracyReport{
+		Particles: len(errors),
+		Theta:     cfg.Theta,
+		Median:    percentile(errors, 0.5),
+		P99:       percentile(errors, 0.99),
+		Max:       percentile(errors, 1.0),
+	}
+}
+
+/* nearest-rank percentile of sorted values */
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
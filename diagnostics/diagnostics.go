@@ -0,0 +1,168 @@
+package diagnostics
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"proj3/nbody"
+)
+
+/* conserved quantities of the system at one step */
+type Sample struct {
+	Step           int
+	Time           float64
+	Kinetic        float64
+	TreePotential  float64 /* from the Barnes Hut walk of the same step */
+	ExactPotential float64 /* direct pair sum, NaN when not requested */
+	Px, Py, Pz     float64
+	Lx, Ly, Lz     float64 /* angular momentum about the origin, only Lz is nonzero in 2D */
+	Virial         float64 /* 2K / |W| */
+	absMomentum    float64 /* sum of m |v|, scale for the momentum drift */
+}
+
+/* total energy, preferring the exact potential when it was computed */
+func (s Sample) Energy() float64 {
+	return s.Kinetic + s.Potential()
+}
+
+func (s Sample) Potential() float64 {
+	if math.IsNaN(s.ExactPotential) {
+		return s.TreePotential
+	}
+	return s.ExactPotential
+}
+
+/* measure the state of particles whose velocities are synchronized with their positions */
+func Measure(particleArray []nbody.Particle, cfg *nbody.SimulationConfig, exact bool) Sample {
+	s := Sample{ExactPotential: math.NaN()}
+	for i := range particleArray {
+		p := &particleArray[i]
+		x, y, z := p.Position()
+		vx, vy, vz := p.Velocity()
+		s.Kinetic += 0.5 * p.Mass * (vx*vx + vy*vy + vz*vz)
+		s.TreePotential += 0.5 * p.Mass * p.Potential()
+		s.Px += p.Mass * vx
+		s.Py += p.Mass * vy
+		s.Pz += p.Mass * vz
+		s.absMomentum += p.Mass * math.Sqrt(vx*vx+vy*vy+vz*vz)
+		s.Lx += p.Mass * (y*vz - z*vy)
+		s.Ly += p.Mass * (z*vx - x*vz)
+		s.Lz += p.Mass * (x*vy - y*vx)
+	}
+	if exact {
+		s.ExactPotential = ExactPotential(particleArray, cfg)
+	}
+	if w := s.Potential(); w != 0 {
+		s.Virial = 2.0 * s.Kinetic / math.Abs(w)
+	}
+	return s
+}
+
+/* potential energy by direct summation over all pairs, O(N^2) */
+func ExactPotential(particleArray []nbody.Particle, cfg *nbody.SimulationConfig) float64 {
+	potential := 0.0
+	for i := range particleArray {
+		xi, yi, zi := particleArray[i].Position()
+		for j := i + 1; j < len(particleArray); j++ {
+			xj, yj, zj := particleArray[j].Position()
+			dx := xj - xi
+			dy := yj - yi
+			dz := zj - zi
+			distSqr := dx*dx + dy*dy + dz*dz + cfg.Softening
+			potential -= cfg.G * particleArray[i].Mass * particleArray[j].Mass / math.Sqrt(distSqr)
+		}
+	}
+	return potential
+}
+
+/* writes a sample every few steps to a time series file and remembers the first and last one */
+type Recorder struct {
+	datafile *os.File
+	every    int
+	exact    bool
+	count    int
+	first    Sample
+	last     Sample
+}
+
+/* create a recorder sampling every k steps, k < 1 is treated as every step */
+func NewRecorder(path string, every int, exact bool) (*Recorder, error) {
+	datafile, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if every < 1 {
+		every = 1
+	}
+	header := "# step time kinetic potential_tree potential_exact total px py pz lx ly lz virial\n"
+	if _, err := datafile.WriteString(header); err != nil {
+		datafile.Close()
+		return nil, err
+	}
+	return &Recorder{datafile: datafile, every: every, exact: exact}, nil
+}
+
+/* continue the samples of an interrupted run in datafile, positioned after the last sample to keep */
+func AppendRecorder(datafile *os.File, every int, exact bool) *Recorder {
+	if every < 1 {
+		every = 1
+	}
+	return &Recorder{datafile: datafile, every: every, exact: exact}
+}
+
+/* record the state at the given step if it falls on the sampling interval */
+func (r *Recorder) Observe(step int, particleArray []nbody.Particle, cfg *nbody.SimulationConfig) error {
+	if step%r.every != 0 {
+		return nil
+	}
+	s := Measure(particleArray, cfg, r.exact)
+	s.Step = step
+	s.Time = float64(step) * cfg.Dt
+	if r.count == 0 {
+		r.first = s
+	}
+	r.last = s
+	r.count++
+
+	content := fmt.Sprintf("%d %.10e %.10e %.10e %.10e %.10e %.10e %.10e %.10e %.10e %.10e %.10e %.10e\n", s.Step, s.Time,
+		s.Kinetic, s.TreePotential, s.ExactPotential, s.Energy(), s.Px, s.Py, s.Pz, s.Lx, s.Ly, s.Lz, s.Virial)
+	_, err := r.datafile.WriteString(content)
+	return err
+}
+
+/* relative change between the first and last recorded samples */
+func (r *Recorder) Report() string {
+	if r.count == 0 {
+		return "Diagnostics: no samples recorded"
+	}
+	first, last := r.first, r.last
+
+	/* total momentum is usually close to zero, so compare its change to the sum of momentum magnitudes */
+	momentumDrift := norm(last.Px-first.Px, last.Py-first.Py, last.Pz-first.Pz)
+	if first.absMomentum != 0 {
+		momentumDrift /= first.absMomentum
+	}
+	angularDrift := norm(last.Lx-first.Lx, last.Ly-first.Ly, last.Lz-first.Lz)
+	if l := norm(first.Lx, first.Ly, first.Lz); l != 0 {
+		angularDrift /= l
+	}
+
+	return fmt.Sprintf("Diagnostics over steps %d-%d: energy drift %.3e, momentum drift %.3e, angular momentum drift %.3e, virial ratio %.4f -> %.4f",
+		first.Step, last.Step, relativeDrift(first.Energy(), last.Energy()), momentumDrift,
+		angularDrift, first.Virial, last.Virial)
+}
+
+func (r *Recorder) Close() error {
+	return r.datafile.Close()
+}
+
+func relativeDrift(initial float64, final float64) float64 {
+	if initial == 0 {
+		return math.Abs(final - initial)
+	}
+	return math.Abs((final - initial) / initial)
+}
+
+func norm(x float64, y float64, z float64) float64 {
+	return math.Sqrt(x*x + y*y + z*z)
+}
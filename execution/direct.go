@@ -0,0 +1,65 @@
+package execution
+
+import "proj3/nbody"
+
+/* O(N^2) accelerations of all particles, split across nThreads goroutines (1 runs sequentially) */
+func ComputeDirectForces(particleArray []nbody.Particle, cfg *nbody.SimulationConfig, nThreads int) {
+	nbody.ParallelRange(len(particleArray), nThreads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			nbody.ComputeDirectForce(particleArray, i, cfg)
+		}
+	})
+}
+
+/* steps with direct summation forces instead of the tree, used as the accuracy reference */
+type DirectExecutor struct {
+	cfg        *nbody.SimulationConfig
+	integrator nbody.Integrator
+	observe    Observer
+	nThreads   int
+	times      PhaseTimes
+}
+
+func NewDirectExecutor(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) *DirectExecutor {
+	return &DirectExecutor{cfg: cfg, integrator: integrator, observe: observe, nThreads: nThreads}
+}
+
+func (e *DirectExecutor) Name() string {
+	return "d"
+}
+
+/* the tree is not used, root may be nil */
+func (e *DirectExecutor) Step(root *nbody.TreeNode, particleArray []nbody.Particle) {
+	cfg, integrator, observe, nThreads := e.cfg, e.integrator, e.observe, e.nThreads
+	var marks phaseMarks
+	marks.record(markStart)
+	marks[markBuilt] = marks[markStart] /* no tree */
+	marks[markCenterOfMass] = marks[markStart]
+	nbody.ParallelRange(len(particleArray), nThreads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			nbody.ComputeDirectForce(particleArray, i, cfg)
+			integrator.Synchronize(&particleArray[i], cfg)
+		}
+	})
+	marks.record(markForce)
+
+	if observe != nil {
+		observe(particleArray)
+	}
+	marks.record(markObserved)
+
+	nbody.ParallelRange(len(particleArray), nThreads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			integrator.Advance(&particleArray[i], cfg)
+		}
+	})
+	marks.record(markEnd)
+	e.times.add(&marks)
+}
+
+func (e *DirectExecutor) Stats() PhaseTimes {
+	return e.times
+}
+
+/* goroutines are started per phase, nothing to release */
+func (e *DirectExecutor) Close() {}
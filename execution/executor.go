@@ -0,0 +1,86 @@
+package execution
+
+import (
+	"fmt"
+	"proj3/nbody"
+	"strings"
+)
+
+/* a strategy for running steps of the simulation, created once and stepped every iteration */
+type Executor interface {
+	Name() string
+	/* one step on the tree rooted at root, which must be empty, returns once every particle has advanced */
+	Step(root *nbody.TreeNode, particleArray []nbody.Particle)
+	/* phase times of all steps so far */
+	Stats() PhaseTimes
+	/* release the goroutines of the executor, it cannot be stepped afterwards */
+	Close()
+}
+
+/* builds an executor for the given configuration, nThreads is ignored by sequential strategies */
+type ExecutorFactory func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor
+
+type registration struct {
+	name        string
+	description string
+	factory     ExecutorFactory
+}
+
+var executors []registration
+
+func init() {
+	Register("s", "sequential Barnes Hut", func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor {
+		return NewSequentialExecutor(integrator, observe)
+	})
+	Register("p", "Barnes Hut on a pool of goroutines with a static split of the particles", func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor {
+		return NewParallelExecutor(integrator, observe, nThreads)
+	})
+	Register("w", "Barnes Hut on a pool of goroutines stealing work from each other", func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor {
+		return NewWorkStealExecutor(integrator, observe, nThreads)
+	})
+	Register("t", "Barnes Hut on a pool of goroutines stealing subtrees of the force walk from each other", func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor {
+		return NewSubtreeTaskExecutor(integrator, observe, nThreads)
+	})
+	Register("d", "direct O(N^2) summation without a tree", func(cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) Executor {
+		return NewDirectExecutor(cfg, integrator, observe, nThreads)
+	})
+}
+
+/* make an executor available under name, replacing any executor registered under the same name */
+func Register(name string, description string, factory ExecutorFactory) {
+	for i := range executors {
+		if executors[i].name == name {
+			executors[i] = registration{name: name, description: description, factory: factory}
+			return
+		}
+	}
+	executors = append(executors, registration{name: name, description: description, factory: factory})
+}
+
+/* look up an executor by name and create it */
+func NewExecutor(name string, cfg *nbody.SimulationConfig, integrator nbody.Integrator, observe Observer, nThreads int) (Executor, error) {
+	for _, r := range executors {
+		if r.name == strings.ToLower(name) {
+			return r.factory(cfg, integrator, observe, nThreads), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown executor %q (available: %s)", name, strings.Join(ExecutorNames(), ", "))
+}
+
+/* names of the registered executors in registration order */
+func ExecutorNames() []string {
+	names := make([]string, 0, len(executors))
+	for _, r := range executors {
+		names = append(names, r.name)
+	}
+	return names
+}
+
+/* one line per registered executor, for usage messages */
+func ExecutorUsage() string {
+	var lines []string
+	for _, r := range executors {
+		lines = append(lines, fmt.Sprintf("  %s\t%s", r.name, r.description))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -6,67 +6,164 @@ import (
     "math"
 )
 
+/* cyclic barrier: the last goroutine to arrive releases the others and resets the count, so one barrier serves every phase of every step */
 type Barrier struct {
     mutex *sync.Mutex
     cond *sync.Cond
     counter int
+    generation int
     threadCount int
 }
 
+func NewBarrier(threadCount int) *Barrier {
+	var mutex sync.Mutex
+	return &Barrier{mutex: &mutex, cond: sync.NewCond(&mutex), threadCount: threadCount}
+}
+
 func (b *Barrier) barrierSync() {
     b.mutex.Lock()
+    generation := b.generation
     b.counter++
     if b.counter < b.threadCount {
-        b.cond.Wait()
+        for generation == b.generation {
+            b.cond.Wait()
+        }
     } else {
+        b.counter = 0
+        b.generation++
         b.cond.Broadcast()
     }
     b.mutex.Unlock()
 }
 
-func nbodyParallel(root *nbody.TreeNode, p []nbody.Particle, start int, end int, threadNum int, b1 *Barrier, b2 *Barrier, b3 *Barrier, wg *sync.WaitGroup) {
-    for i := start; i < end; i++ {
-        nbody.TreeInsert(root, &p[i], true)
+/* nThreads goroutines, their barriers and the per step state, kept alive from the first Step until Close */
+type ParallelExecutor struct {
+	integrator nbody.Integrator
+	observe Observer
+	nThreads int
+	pool *Barrier	/* the workers and the goroutine calling Step, met once to start and once to finish a step */
+	phase *Barrier	/* the workers, between the phases of a step */
+	wg sync.WaitGroup
+	closed bool
+
+	root *nbody.TreeNode
+	particleArray []nbody.Particle
+	comPass nbody.CenterOfMassPass
+	order []*nbody.Particle	/* particles in tree order, split into cost zones by bounds */
+	bounds []int
+	marks phaseMarks
+	times PhaseTimes
+}
+
+func NewParallelExecutor(integrator nbody.Integrator, observe Observer, nThreads int) *ParallelExecutor {
+	e := &ParallelExecutor{integrator: integrator, observe: observe, nThreads: nThreads,
+		pool: NewBarrier(nThreads + 1), phase: NewBarrier(nThreads)}
+	e.wg.Add(nThreads)
+	for i := 0; i < nThreads; i++ {
+		go e.worker(i)
+	}
+	return e
+}
+
+func (e *ParallelExecutor) worker(threadNum int) {
+	for {
+		e.pool.barrierSync()
+		if e.closed {
+			e.wg.Done()
+			return
+		}
+		nbodyParallel(e, threadNum)
+		e.pool.barrierSync()
+	}
+}
+
+func nbodyParallel(e *ParallelExecutor, threadNum int) {
+    root, p, integrator, b := e.root, e.particleArray, e.integrator, e.phase
+    cfg := root.Config()
+    particlesPerThread := int(math.Ceil(float64(len(p)) / float64(e.nThreads)))
+    start, end := nbody.GetStartAndEnd(threadNum, len(p), particlesPerThread)
+
+    if cfg.TreeBuilder != nbody.BuilderMorton {
+        for i := start; i < end; i++ {
+            nbody.TreeInsert(root, &p[i], true)
+        }
     }
 
-    b1.barrierSync()
+    b.barrierSync()
+    if threadNum == 0 {
+        e.marks.record(markBuilt)
+    }
 
+    costZones := cfg.Partition == nbody.PartitionCostZones
+    if threadNum == 0 && costZones {    /* the others start on the center of mass pass meanwhile */
+        e.order, e.bounds = nbody.CostZones(root, e.nThreads, e.order, e.bounds)
+    }
+    e.comPass.Run(root, e.nThreads)
+
+    b.barrierSync()
     if threadNum == 0 {
-        nbody.PopulateCenterOfMass(root)
+        e.marks.record(markCenterOfMass)
     }
 
-    b2.barrierSync()
+    if costZones {
+        for _, q := range e.order[e.bounds[threadNum]:e.bounds[threadNum+1]] {
+            nbody.ComputeForce(root, q)
+            integrator.Synchronize(q, cfg)
+        }
+    } else {
+        for i := start; i < end; i++ {
+            nbody.ComputeForce(root, &p[i])
+            integrator.Synchronize(&p[i], cfg)
+        }
+    }
 
-    for i := start; i < end; i++ {
-        nbody.ComputeNodeForce(root, p[i].Node)
+    b.barrierSync()
+    if threadNum == 0 {
+        e.marks.record(markForce)
     }
 
-    b3.barrierSync()
+    if e.observe != nil {
+        if threadNum == 0 {
+            e.observe(p)
+        }
+        b.barrierSync()
+    }
+    if threadNum == 0 {
+        e.marks.record(markObserved)
+    }
 
     for i := start; i < end; i++ {
-        nbody.UpdatePosition(&p[i])
+        integrator.Advance(&p[i], cfg)
     }
-    
-    wg.Done()
 }
 
-func RunParallel(root *nbody.TreeNode, particleArray []nbody.Particle, nThreads int) {
-    nParticles := len(particleArray)
-    particlesPerThread := int(math.Ceil(float64(nParticles) / float64(nThreads)))
-
-	var mutex1, mutex2, mutex3 sync.Mutex
-	cond1 := sync.NewCond(&mutex1)
-	cond2 := sync.NewCond(&mutex2)
-	cond3 := sync.NewCond(&mutex3)
-	b1 := Barrier{mutex: &mutex1, cond: cond1, counter: 0, threadCount: nThreads}
-	b2 := Barrier{mutex: &mutex2, cond: cond2, counter: 0, threadCount: nThreads}
-	b3 := Barrier{mutex: &mutex3, cond: cond3, counter: 0, threadCount: nThreads}
-
-	var wg sync.WaitGroup
-	for i:= 0; i < nThreads; i++ {
-		start, end := nbody.GetStartAndEnd(i, nParticles, particlesPerThread)
-		wg.Add(1)
-		go nbodyParallel(root, particleArray, start, end, i, &b1, &b2, &b3, &wg)
+/* one step on the tree rooted at root, which must be empty, returns once every particle has advanced */
+func (e *ParallelExecutor) Step(root *nbody.TreeNode, particleArray []nbody.Particle) {
+	e.root, e.particleArray = root, particleArray
+	e.comPass = nbody.CenterOfMassPass{}
+	e.marks.record(markStart)
+	if root.Config().TreeBuilder == nbody.BuilderMorton {
+		nbody.BuildMortonTree(root, particleArray, e.nThreads)
 	}
-	wg.Wait()
+
+	e.pool.barrierSync()
+	e.pool.barrierSync()
+	e.marks.record(markEnd)
+	e.times.add(&e.marks)
+}
+
+func (e *ParallelExecutor) Name() string {
+	return "p"
+}
+
+/* phase times of all steps so far */
+func (e *ParallelExecutor) Stats() PhaseTimes {
+	return e.times
+}
+
+/* stop the workers, the executor cannot be stepped afterwards */
+func (e *ParallelExecutor) Close() {
+	e.closed = true
+	e.pool.barrierSync()
+	e.wg.Wait()
 }
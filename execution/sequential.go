@@ -2,16 +2,62 @@ package execution
 
 import "proj3/nbody"
 
-func RunSequential(root *nbody.TreeNode, particleArray []nbody.Particle) {
+/* called once per step after forces are computed and velocities synchronized, before particles advance */
+type Observer func(particleArray []nbody.Particle)
+
+/* the whole step on the calling goroutine */
+type SequentialExecutor struct {
+	integrator nbody.Integrator
+	observe    Observer
+	times      PhaseTimes
+}
+
+func NewSequentialExecutor(integrator nbody.Integrator, observe Observer) *SequentialExecutor {
+	return &SequentialExecutor{integrator: integrator, observe: observe}
+}
+
+func (e *SequentialExecutor) Name() string {
+	return "s"
+}
+
+func (e *SequentialExecutor) Step(root *nbody.TreeNode, particleArray []nbody.Particle) {
 	nParticles := len(particleArray)
-	for i := 0; i < nParticles; i++ {
-		nbody.TreeInsert(root, &particleArray[i], false)
+	cfg := root.Config()
+	integrator, observe := e.integrator, e.observe
+	var marks phaseMarks
+	marks.record(markStart)
+	if cfg.TreeBuilder == nbody.BuilderMorton {
+		nbody.BuildMortonTree(root, particleArray, 1)
+	} else {
+		for i := 0; i < nParticles; i++ {
+			nbody.TreeInsert(root, &particleArray[i], false)
+		}
 	}
+	marks.record(markBuilt)
 
 	nbody.PopulateCenterOfMass(root)
+	marks.record(markCenterOfMass)
 	nbody.TraverseTree(root, root)
 
 	for i := 0; i < nParticles; i++ {
-		nbody.UpdatePosition(&particleArray[i], )
+		integrator.Synchronize(&particleArray[i], cfg)
+	}
+	marks.record(markForce)
+
+	if observe != nil {
+		observe(particleArray)
+	}
+	marks.record(markObserved)
+
+	for i := 0; i < nParticles; i++ {
+		integrator.Advance(&particleArray[i], cfg)
 	}
-}
\ No newline at end of file
+	marks.record(markEnd)
+	e.times.add(&marks)
+}
+
+func (e *SequentialExecutor) Stats() PhaseTimes {
+	return e.times
+}
+
+func (e *SequentialExecutor) Close() {}
@@ -0,0 +1,69 @@
+package execution
+
+import (
+	"fmt"
+	"time"
+)
+
+/* wall clock time spent in each phase of a step, summed over steps */
+type PhaseTimes struct {
+	Steps        int
+	Build        time.Duration /* tree construction */
+	CenterOfMass time.Duration /* upward pass */
+	Force        time.Duration /* tree walks and velocity synchronization */
+	Observe      time.Duration
+	Advance      time.Duration
+}
+
+/* points in time between the phases of one step, taken by a single goroutine right after each barrier */
+type phaseMarks [6]time.Time
+
+const (
+	markStart = iota
+	markBuilt
+	markCenterOfMass
+	markForce
+	markObserved
+	markEnd
+)
+
+func (m *phaseMarks) record(mark int) {
+	m[mark] = time.Now()
+}
+
+func (t *PhaseTimes) add(m *phaseMarks) {
+	if t == nil {
+		return
+	}
+	t.Steps++
+	t.Build += m[markBuilt].Sub(m[markStart])
+	t.CenterOfMass += m[markCenterOfMass].Sub(m[markBuilt])
+	t.Force += m[markForce].Sub(m[markCenterOfMass])
+	t.Observe += m[markObserved].Sub(m[markForce])
+	t.Advance += m[markEnd].Sub(m[markObserved])
+}
+
+/* add the steps timed in other, nothing happens on a nil receiver */
+func (t *PhaseTimes) Merge(other *PhaseTimes) {
+	if t == nil {
+		return
+	}
+	t.Steps += other.Steps
+	t.Build += other.Build
+	t.CenterOfMass += other.CenterOfMass
+	t.Force += other.Force
+	t.Observe += other.Observe
+	t.Advance += other.Advance
+}
+
+/* average time per step of every phase */
+func (t *PhaseTimes) String() string {
+	if t.Steps == 0 {
+		return "no steps timed"
+	}
+	perStep := func(d time.Duration) string {
+		return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond)/float64(t.Steps))
+	}
+	return fmt.Sprintf("build %s, center of mass %s, force %s, observe %s, advance %s per step over %d steps",
+		perStep(t.Build), perStep(t.CenterOfMass), perStep(t.Force), perStep(t.Observe), perStep(t.Advance), t.Steps)
+}
@@ -5,90 +5,271 @@ import (
 	"math/rand"
 	"proj3/queue"
 	"proj3/nbody"
+	"runtime"
 	"sync"
 	"sync/atomic"
 )
 
-func nbodyWorkSteal(root *nbody.TreeNode, particleArray []nbody.Particle, start int, end int, threadNum int, nThreads int32, b1 *Barrier, b2 *Barrier, b3 *Barrier, insertQueues []*queue.DEQueue, computeQueues []*queue.DEQueue, wg *sync.WaitGroup, insertCount *int32, computeCount *int32) {
-	for {
-		particleIdx := insertQueues[threadNum].PopBottom()
-		if particleIdx == -1 {
-			break
+/*
+like ParallelExecutor, with insertion and force work handed out through deques that idle workers steal from.
+The force work is either single particles or, with subtree tasks, subtrees of the tree whose particles are
+walked together.
+*/
+type WorkStealExecutor struct {
+	integrator nbody.Integrator
+	observe Observer
+	nThreads int
+	subtreeTasks bool
+	taskQueues []*queue.Deque[nbody.TreeNode]
+	pool *Barrier
+	phase *Barrier
+	wg sync.WaitGroup
+	closed bool
+	insertQueues []*queue.Deque[nbody.Particle]
+	computeQueues []*queue.Deque[nbody.Particle]
+
+	root *nbody.TreeNode
+	particleArray []nbody.Particle
+	insertCount int32
+	computeCount int32
+	remaining int64	/* particles whose forces the subtree tasks have not computed yet */
+	comPass nbody.CenterOfMassPass
+	marks phaseMarks
+	times PhaseTimes
+}
+
+func NewWorkStealExecutor(integrator nbody.Integrator, observe Observer, nThreads int) *WorkStealExecutor {
+	return newWorkStealExecutor(integrator, observe, nThreads, false)
+}
+
+/* work stealing executor whose force phase runs subtree tasks instead of single particles */
+func NewSubtreeTaskExecutor(integrator nbody.Integrator, observe Observer, nThreads int) *WorkStealExecutor {
+	return newWorkStealExecutor(integrator, observe, nThreads, true)
+}
+
+func newWorkStealExecutor(integrator nbody.Integrator, observe Observer, nThreads int, subtreeTasks bool) *WorkStealExecutor {
+	e := &WorkStealExecutor{integrator: integrator, observe: observe, nThreads: nThreads, subtreeTasks: subtreeTasks,
+		pool: NewBarrier(nThreads + 1), phase: NewBarrier(nThreads),
+		insertQueues: make([]*queue.Deque[nbody.Particle], nThreads), computeQueues: make([]*queue.Deque[nbody.Particle], nThreads)}
+	for i := 0; i < nThreads; i++ {
+		e.insertQueues[i] = queue.NewDeque[nbody.Particle](0)
+		e.computeQueues[i] = queue.NewDeque[nbody.Particle](0)
+	}
+	if subtreeTasks {
+		e.taskQueues = make([]*queue.Deque[nbody.TreeNode], nThreads)
+		for i := 0; i < nThreads; i++ {
+			e.taskQueues[i] = queue.NewDeque[nbody.TreeNode](0)
 		}
-        nbody.TreeInsert(root, &particleArray[particleIdx], true)
-    }
-	atomic.AddInt32(insertCount, 1)
+	}
+	e.wg.Add(nThreads)
+	for i := 0; i < nThreads; i++ {
+		go e.worker(i)
+	}
+	return e
+}
 
-	for *insertCount < nThreads {
-		idx := rand.Int31n(nThreads)
-		particleIdx := insertQueues[idx].PopTop()
-		if particleIdx == -1 {
-			continue
-		} 
-		nbody.TreeInsert(root, &particleArray[particleIdx], true)
+func (e *WorkStealExecutor) worker(threadNum int) {
+	for {
+		e.pool.barrierSync()
+		if e.closed {
+			e.wg.Done()
+			return
+		}
+		nbodyWorkSteal(e, threadNum)
+		e.pool.barrierSync()
 	}
+}
 
-    b1.barrierSync()
+func nbodyWorkSteal(e *WorkStealExecutor, threadNum int) {
+	root, particleArray, integrator, b := e.root, e.particleArray, e.integrator, e.phase
+	insertQueues, computeQueues := e.insertQueues, e.computeQueues
+	nThreads := int32(e.nThreads)
+	cfg := root.Config()
+	if cfg.TreeBuilder != nbody.BuilderMorton {
+		for {
+			p := insertQueues[threadNum].PopBottom()
+			if p == nil {
+				break
+			}
+			nbody.TreeInsert(root, p, true)
+		}
+		atomic.AddInt32(&e.insertCount, 1)
+
+		for atomic.LoadInt32(&e.insertCount) < nThreads {
+			idx := rand.Int31n(nThreads)
+			p := insertQueues[idx].Steal()
+			if p == nil {
+				runtime.Gosched() /* let the workers still inserting run when there are fewer cores than workers */
+				continue
+			}
+			nbody.TreeInsert(root, p, true)
+		}
+	}
 
+    b.barrierSync()
     if threadNum == 0 {
-        nbody.PopulateCenterOfMass(root)
+        e.marks.record(markBuilt)
     }
 
-    b2.barrierSync()
+    e.comPass.Run(root, e.nThreads)
 
-    for {
-		particleIdx := computeQueues[threadNum].PopBottom() 
-		if particleIdx == -1 {
-			break
-		}
-        nbody.ComputeNodeForce(root, particleArray[particleIdx].Node)
+    b.barrierSync()
+    if threadNum == 0 {
+        e.marks.record(markCenterOfMass)
     }
-	atomic.AddInt32(computeCount, 1)
 
-	for *computeCount < nThreads {
-		idx := rand.Int31n(nThreads)
-		particleIdx := computeQueues[idx].PopTop()
-		if particleIdx == -1 {
-			continue
-		} 
-		nbody.ComputeNodeForce(root, particleArray[particleIdx].Node)
+	if e.subtreeTasks {
+		runSubtreeTasks(e, threadNum)
+	} else {
+		for {
+			p := computeQueues[threadNum].PopBottom()
+			if p == nil {
+				break
+			}
+			nbody.ComputeForce(root, p)
+			integrator.Synchronize(p, cfg)
+		}
+		atomic.AddInt32(&e.computeCount, 1)
+
+		for atomic.LoadInt32(&e.computeCount) < nThreads {
+			idx := rand.Int31n(nThreads)
+			p := computeQueues[idx].Steal()
+			if p == nil {
+				runtime.Gosched()
+				continue
+			}
+			nbody.ComputeForce(root, p)
+			integrator.Synchronize(p, cfg)
+		}
 	}
 
-    b3.barrierSync()
+    b.barrierSync()
+    if threadNum == 0 {
+        e.marks.record(markForce)
+    }
+
+    if e.observe != nil {
+        if threadNum == 0 {
+            e.observe(particleArray)
+        }
+        b.barrierSync()
+    }
+    if threadNum == 0 {
+        e.marks.record(markObserved)
+    }
 
+    particlesPerThread := int(math.Ceil(float64(len(particleArray)) / float64(e.nThreads)))
+    start, end := nbody.GetStartAndEnd(threadNum, len(particleArray), particlesPerThread)
     for i := start; i < end; i++ {
-        nbody.UpdatePosition(&particleArray[i])
+        integrator.Advance(&particleArray[i], cfg)
     }
-    wg.Done()
 }
 
-func RunWorkSteal(root *nbody.TreeNode, particleArray []nbody.Particle, nThreads int) {
-	nParticles := len(particleArray)
-    particlesPerThread := int(math.Ceil(float64(nParticles) / float64(nThreads)))
+/*
+force phase over subtree tasks: thread 0 seeds its deque with the root, a task above the cutoff depth pushes
+its non-empty children onto the deque of the worker running it and a deeper task computes the forces on all
+particles of its subtree, so that particles close in space are walked one after another by the same worker.
+*/
+func runSubtreeTasks(e *WorkStealExecutor, threadNum int) {
+	root, integrator := e.root, e.integrator
+	cfg := root.Config()
+	own := e.taskQueues[threadNum]
+	cutoff := taskCutoffDepth(cfg, e.nThreads)
+	if threadNum == 0 && root.ParticleCount() > 0 {
+		own.PushBottom(root)
+	}
 
-	var mutex1, mutex2, mutex3 sync.Mutex
-	cond1 := sync.NewCond(&mutex1)
-	cond2 := sync.NewCond(&mutex2)
-	cond3 := sync.NewCond(&mutex3)
-	b1 := Barrier{mutex: &mutex1, cond: cond1, counter: 0, threadCount: nThreads}
-	b2 := Barrier{mutex: &mutex2, cond: cond2, counter: 0, threadCount: nThreads}
-	b3 := Barrier{mutex: &mutex3, cond: cond3, counter: 0, threadCount: nThreads}
+	computed := 0
+	visit := func(p *nbody.Particle) {
+		nbody.ComputeForce(root, p)
+		integrator.Synchronize(p, cfg)
+		computed++
+	}
+	for atomic.LoadInt64(&e.remaining) > 0 {
+		t := own.PopBottom()
+		if t == nil {
+			t = e.taskQueues[rand.Intn(e.nThreads)].Steal()
+			if t == nil {
+				runtime.Gosched() /* let the workers holding tasks run when there are fewer cores than workers */
+				continue
+			}
+		}
+		if t.Depth() < cutoff && !t.IsLeaf() {
+			for _, child := range t.Children() {
+				if child != nil && child.ParticleCount() > 0 {
+					own.PushBottom(child)
+				}
+			}
+			continue
+		}
+		computed = 0
+		nbody.ForEachParticle(t, visit)
+		atomic.AddInt64(&e.remaining, -int64(computed))
+	}
+}
 
-	insertQueues := make([]*queue.DEQueue, nThreads)
-	computeQueues := make([]*queue.DEQueue, nThreads)
+/* tasks per worker the automatic cutoff aims for, enough to even out subtrees of different sizes */
+const tasksPerThread = 8
 
-	var wg sync.WaitGroup
-	for i:= 0; i < nThreads; i++ {
-		start, end := nbody.GetStartAndEnd(i, nParticles, particlesPerThread)
-		insertQueues[i] = queue.NewDEQueue(start, end)
-		computeQueues[i] = queue.NewDEQueue(start, end)
+/* depth at which subtree tasks stop splitting: cfg.TaskDepth, or the first depth a full tree has enough nodes at */
+func taskCutoffDepth(cfg *nbody.SimulationConfig, nThreads int) int {
+	if cfg.TaskDepth > 0 {
+		return cfg.TaskDepth
+	}
+	depth := 1
+	for tasks := 1 << cfg.Dim; tasks < tasksPerThread*nThreads; tasks <<= cfg.Dim {
+		depth++
 	}
+	return depth
+}
 
-	var insertCount, computeCount int32 = 0, 0
-	for i := 0; i < nThreads; i++ {
+/* one step on the tree rooted at root, which must be empty, returns once every particle has advanced */
+func (e *WorkStealExecutor) Step(root *nbody.TreeNode, particleArray []nbody.Particle) {
+	nParticles := len(particleArray)
+	particlesPerThread := int(math.Ceil(float64(nParticles) / float64(e.nThreads)))
+	morton := root.Config().TreeBuilder == nbody.BuilderMorton
+
+	e.root, e.particleArray = root, particleArray
+	e.insertCount, e.computeCount = 0, 0
+	e.remaining = int64(nParticles)
+	e.comPass = nbody.CenterOfMassPass{}
+	e.marks.record(markStart)
+	if morton {
+		nbody.BuildMortonTree(root, particleArray, e.nThreads)
+	}
+	for i := 0; i < e.nThreads; i++ {
 		start, end := nbody.GetStartAndEnd(i, nParticles, particlesPerThread)
-		wg.Add(1)
-		go nbodyWorkSteal(root, particleArray, start, end, i, int32(nThreads), &b1, &b2, &b3, insertQueues, computeQueues, &wg, &insertCount, &computeCount)
+		for j := start; j < end; j++ { /* the workers are waiting, so this goroutine may push on their behalf */
+			if !morton {
+				e.insertQueues[i].PushBottom(&particleArray[j])
+			}
+			if !e.subtreeTasks {
+				e.computeQueues[i].PushBottom(&particleArray[j])
+			}
+		}
 	}
-	wg.Wait()
-}
\ No newline at end of file
+
+	e.pool.barrierSync()
+	e.pool.barrierSync()
+	e.marks.record(markEnd)
+	e.times.add(&e.marks)
+}
+
+func (e *WorkStealExecutor) Name() string {
+	if e.subtreeTasks {
+		return "t"
+	}
+	return "w"
+}
+
+/* phase times of all steps so far */
+func (e *WorkStealExecutor) Stats() PhaseTimes {
+	return e.times
+}
+
+/* stop the workers, the executor cannot be stepped afterwards */
+func (e *WorkStealExecutor) Close() {
+	e.closed = true
+	e.pool.barrierSync()
+	e.wg.Wait()
+}
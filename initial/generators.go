@@ -0,0 +1,144 @@
+package initial
+
+import (
+	"fmt"
+	"math"
+	"proj3/nbody"
+	"strings"
+)
+
+/* parameters shared by all generated models */
+type Params struct {
+	N      int
+	Radius float64     /* scale radius of the model, see the description of each generator */
+	Mass   float64     /* total mass */
+	Seed   int64       /* seed of the random draws */
+	Rand   *nbody.Rand /* drawn from instead of a generator seeded with Seed when set, e.g. to checkpoint its state */
+	Dim    int         /* 2 or 3 */
+	G      float64     /* gravitational constant the velocities are computed for */
+}
+
+/* a named model of a self-gravitating system */
+type Generator struct {
+	Name        string
+	Description string
+	Dims        []int /* dimensions the model exists in */
+	generate    func(p Params, r *nbody.Rand) ([]nbody.Particle, error)
+}
+
+var generators = []Generator{
+	{"plummer", "Plummer sphere with scale radius R in isotropic equilibrium", []int{3}, plummer},
+	{"hernquist", "Hernquist sphere with scale radius R in isotropic equilibrium", []int{3}, hernquist},
+	{"kepler-disk", "uniform disk out to R on circular orbits around a central body with 99% of the mass", []int{2, 3}, keplerDisk},
+	{"galaxy", "exponential disk with scale length R on circular orbits, with a Hernquist bulge holding 20% of the mass", []int{2, 3}, galaxy},
+	{"cold-collapse", "uniform sphere of radius R at rest", []int{3}, coldCollapse},
+	{"lattice", "square or cubic lattice filling [-R, R] at rest", []int{2, 3}, lattice},
+	{"two-galaxies", "two galaxy models with half of the particles each on a parabolic encounter from 10 R with pericenter 2 R", []int{2, 3}, twoGalaxies},
+}
+
+/* look up a generator by name */
+func GetGenerator(name string) (Generator, error) {
+	for _, generator := range generators {
+		if generator.Name == strings.ToLower(name) {
+			return generator, nil
+		}
+	}
+	return Generator{}, fmt.Errorf("unknown initial conditions %q (available: %s)", name, strings.Join(GeneratorNames(), ", "))
+}
+
+func GeneratorNames() []string {
+	names := make([]string, 0, len(generators))
+	for _, generator := range generators {
+		names = append(names, generator.Name)
+	}
+	return names
+}
+
+/*
+draw the particles of the model, with masses adding up to p.Mass, IDs 0 to N - 1, and the center of mass at
+rest at the origin
+*/
+func (g Generator) Generate(p Params) ([]nbody.Particle, error) {
+	if p.N < 1 {
+		return nil, fmt.Errorf("%s: number of particles must be positive, got %d", g.Name, p.N)
+	}
+	if !(p.Radius > 0) || math.IsInf(p.Radius, 0) {
+		return nil, fmt.Errorf("%s: radius must be positive and finite, got %g", g.Name, p.Radius)
+	}
+	if !(p.Mass > 0) || math.IsInf(p.Mass, 0) {
+		return nil, fmt.Errorf("%s: mass must be positive and finite, got %g", g.Name, p.Mass)
+	}
+	if !(p.G > 0) {
+		return nil, fmt.Errorf("%s: G must be positive, got %g", g.Name, p.G)
+	}
+	supported := false
+	for _, dim := range g.Dims {
+		supported = supported || dim == p.Dim
+	}
+	if !supported {
+		return nil, fmt.Errorf("%s is not available in %dD", g.Name, p.Dim)
+	}
+	r := p.Rand
+	if r == nil {
+		r = nbody.NewRand(p.Seed)
+	}
+	particleArray, err := g.generate(p, r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", g.Name, err)
+	}
+	nbody.AssignIDs(particleArray, 0)
+	toCenterOfMassFrame(particleArray)
+	return particleArray, nil
+}
+
+/* one line per generator for usage messages */
+func GeneratorUsage() string {
+	var lines []string
+	for _, generator := range generators {
+		lines = append(lines, fmt.Sprintf("  %s\t%s", generator.Name, generator.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+/* shift positions and velocities so that the center of mass is at rest at the origin */
+func toCenterOfMassFrame(particleArray []nbody.Particle) {
+	var mass, cx, cy, cz, cvx, cvy, cvz float64
+	for i := range particleArray {
+		p := &particleArray[i]
+		x, y, z := p.Position()
+		vx, vy, vz := p.Velocity()
+		mass += p.Mass
+		cx, cy, cz = cx+p.Mass*x, cy+p.Mass*y, cz+p.Mass*z
+		cvx, cvy, cvz = cvx+p.Mass*vx, cvy+p.Mass*vy, cvz+p.Mass*vz
+	}
+	cx, cy, cz, cvx, cvy, cvz = cx/mass, cy/mass, cz/mass, cvx/mass, cvy/mass, cvz/mass
+	for i := range particleArray {
+		p := &particleArray[i]
+		x, y, z := p.Position()
+		vx, vy, vz := p.Velocity()
+		p.SetPosition(x-cx, y-cy, z-cz)
+		p.SetVelocity(vx-cvx, vy-cvy, vz-cvz)
+	}
+}
+
+/* uniformly distributed unit vector */
+func isotropic(r *nbody.Rand) (float64, float64, float64) {
+	cosTheta := 2*r.Float64() - 1
+	sinTheta := math.Sqrt(1 - cosTheta*cosTheta)
+	phi := 2 * math.Pi * r.Float64()
+	return sinTheta * math.Cos(phi), sinTheta * math.Sin(phi), cosTheta
+}
+
+/* particles of mass m each at the radii and speeds from draw, in isotropic directions */
+func sphere(n int, m float64, r *nbody.Rand, draw func() (float64, float64)) ([]nbody.Particle, error) {
+	particleArray := make([]nbody.Particle, n)
+	for i := range particleArray {
+		rad, speed := draw()
+		x, y, z := isotropic(r)
+		vx, vy, vz := isotropic(r)
+		particleArray[i].SetPosition(rad*x, rad*y, rad*z)
+		particleArray[i].SetVelocity(speed*vx, speed*vy, speed*vz)
+		particleArray[i].Mass = m
+	}
+	return particleArray, nil
+}
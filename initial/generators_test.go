@@ -0,0 +1,161 @@
+package initial
+
+import (
+	"math"
+	"proj3/nbody"
+	"strings"
+	"testing"
+)
+
+/* kinetic and potential energy by direct summation, without softening */
+func energies(particleArray []nbody.Particle, G float64) (float64, float64) {
+	var kinetic, potential float64
+	for i := range particleArray {
+		p := &particleArray[i]
+		vx, vy, vz := p.Velocity()
+		kinetic += 0.5 * p.Mass * (vx*vx + vy*vy + vz*vz)
+		x, y, z := p.Position()
+		for j := i + 1; j < len(particleArray); j++ {
+			x2, y2, z2 := particleArray[j].Position()
+			potential -= G * p.Mass * particleArray[j].Mass / math.Sqrt((x-x2)*(x-x2)+(y-y2)*(y-y2)+(z-z2)*(z-z2))
+		}
+	}
+	return kinetic, potential
+}
+
+/* the spheres start in equilibrium, with the potential energy of the model up to the cutoff and sampling noise */
+func TestSphereEquilibrium(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		potential float64 /* of the model without cutoff, for G = M = R = 1 */
+	}{
+		{"plummer", -3 * math.Pi / 32},
+		{"hernquist", -1.0 / 6},
+	} {
+		generator, err := GetGenerator(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const G, mass, radius = 2, 3, 0.5
+		particleArray, err := generator.Generate(Params{N: 2000, Radius: radius, Mass: mass, Seed: 1, Dim: 3, G: G})
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinetic, potential := energies(particleArray, G)
+		expected := c.potential * G * mass * mass / radius
+		if math.Abs(potential/expected-1) > 0.05 {
+			t.Errorf("%s: potential energy %g, expected %g", c.name, potential, expected)
+		}
+		if ratio := 2 * kinetic / -potential; math.Abs(ratio-1) > 0.1 {
+			t.Errorf("%s: virial ratio 2K/|W| = %g", c.name, ratio)
+		}
+	}
+}
+
+/* every model has N particles with the total mass, IDs 0 to N - 1 and its center of mass at rest at the origin */
+func TestGeneratedBookkeeping(t *testing.T) {
+	for _, generator := range generators {
+		for _, dim := range generator.Dims {
+			for _, n := range []int{2, 101} {
+				particleArray, err := generator.Generate(Params{N: n, Radius: 2, Mass: 5, Seed: 3, Dim: dim, G: 1})
+				if err != nil {
+					t.Fatalf("%s in %dD with %d particles: %v", generator.Name, dim, n, err)
+				}
+				if len(particleArray) != n {
+					t.Fatalf("%s in %dD: %d particles, expected %d", generator.Name, dim, len(particleArray), n)
+				}
+				var mass, cx, cy, cz, px, py, pz float64
+				for i := range particleArray {
+					p := &particleArray[i]
+					if p.ID != uint64(i) {
+						t.Fatalf("%s in %dD: particle %d has ID %d", generator.Name, dim, i, p.ID)
+					}
+					if !(p.Mass > 0) {
+						t.Fatalf("%s in %dD: particle %d has mass %g", generator.Name, dim, i, p.Mass)
+					}
+					x, y, z := p.Position()
+					vx, vy, vz := p.Velocity()
+					if dim == 2 && (z != 0 || vz != 0) {
+						t.Fatalf("%s in 2D: particle %d leaves the plane", generator.Name, i)
+					}
+					mass += p.Mass
+					cx, cy, cz = cx+p.Mass*x, cy+p.Mass*y, cz+p.Mass*z
+					px, py, pz = px+p.Mass*vx, py+p.Mass*vy, pz+p.Mass*vz
+				}
+				if math.Abs(mass-5) > 1e-12 {
+					t.Errorf("%s in %dD with %d particles: total mass %g", generator.Name, dim, n, mass)
+				}
+				if math.Abs(cx)+math.Abs(cy)+math.Abs(cz) > 1e-9 || math.Abs(px)+math.Abs(py)+math.Abs(pz) > 1e-9 {
+					t.Errorf("%s in %dD with %d particles: center of mass at (%g, %g, %g) with momentum (%g, %g, %g)",
+						generator.Name, dim, n, cx, cy, cz, px, py, pz)
+				}
+			}
+		}
+	}
+}
+
+func TestGeneratorDims(t *testing.T) {
+	for _, generator := range generators {
+		for _, dim := range []int{2, 3} {
+			supported := false
+			for _, d := range generator.Dims {
+				supported = supported || d == dim
+			}
+			_, err := generator.Generate(Params{N: 10, Radius: 1, Mass: 1, Dim: dim, G: 1})
+			if supported && err != nil {
+				t.Errorf("%s in %dD: %v", generator.Name, dim, err)
+			}
+			if !supported && (err == nil || !strings.Contains(err.Error(), "not available in")) {
+				t.Errorf("%s in %dD: expected to be rejected, got %v", generator.Name, dim, err)
+			}
+		}
+	}
+}
+
+/* the same seed draws the same particles, a shared generator continues where the previous draws stopped */
+func TestGeneratorSeed(t *testing.T) {
+	generator, err := GetGenerator("Plummer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := Params{N: 50, Radius: 1, Mass: 1, Seed: 7, Dim: 3, G: 1}
+	first, _ := generator.Generate(params)
+	second, _ := generator.Generate(params)
+	for i := range first {
+		if first[i].State() != second[i].State() {
+			t.Fatalf("particle %d differs between runs with the same seed", i)
+		}
+	}
+	params.Seed = 8
+	other, _ := generator.Generate(params)
+	if other[0].State() == first[0].State() {
+		t.Fatal("another seed drew the same particles")
+	}
+
+	params.Seed, params.Rand = 0, nbody.NewRand(7)
+	shared, _ := generator.Generate(params)
+	if shared[0].State() != first[0].State() {
+		t.Fatal("a generator seeded with the seed drew other particles")
+	}
+	if params.Rand.State().Draws == 0 {
+		t.Fatal("the shared generator was not drawn from")
+	}
+}
+
+func TestGeneratorParams(t *testing.T) {
+	if _, err := GetGenerator("spiral"); err == nil || !strings.Contains(err.Error(), "available: plummer") {
+		t.Errorf("unknown model: %v", err)
+	}
+	generator, _ := GetGenerator("lattice")
+	for _, params := range []Params{
+		{N: 0, Radius: 1, Mass: 1, Dim: 2, G: 1},
+		{N: 4, Radius: 0, Mass: 1, Dim: 2, G: 1},
+		{N: 4, Radius: math.Inf(1), Mass: 1, Dim: 2, G: 1},
+		{N: 4, Radius: 1, Mass: math.NaN(), Dim: 2, G: 1},
+		{N: 4, Radius: 1, Mass: 1, Dim: 2, G: 0},
+	} {
+		if _, err := generator.Generate(params); err == nil {
+			t.Errorf("%+v accepted", params)
+		}
+	}
+}
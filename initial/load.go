@@ -0,0 +1,421 @@
+/*
+Package initial provides initial conditions for a simulation, either generated from a model or read from
+files.
+
+GetGenerator looks up a model by name (plummer, hernquist, kepler-disk, galaxy, cold-collapse, lattice,
+two-galaxies, see GeneratorUsage) and Generate draws its particles for the number of particles, radius, mass,
+seed and dimension given in Params. A Scenario combines several systems, generated or read from files, each
+rotated and placed at its own position and velocity, into the particles of one run. TwoGalaxies builds the
+encounter of two galaxy models and LoadScenario reads a scenario from a JSON file.
+
+Particle files are read by Load, ReadCSV and ReadJSON. CSV files have one particle per line and, by default,
+a header line naming the columns. JSON files hold an array of objects, one per particle, either at the top
+level or under a "particles" key. Fields are read from the columns or keys of the same name unless a column
+mapping says otherwise:
+
+	x, y, z      position, z only in 3D
+	vx, vy, vz   velocity, 0 when missing, vz only in 3D
+	mass         mass, must be positive
+	id           particle ID, the index in the file when missing, must be unique
+	type, group  optional labels stored in the particle's metadata
+*/
+package initial
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"proj3/nbody"
+	"strconv"
+	"strings"
+)
+
+/* fields a file can provide, in the order of a 3D CSV file without header, 2D files leave out z and vz */
+var Fields = []string{"x", "y", "z", "vx", "vy", "vz", "mass", "id", "type", "group"}
+
+/* options of Load */
+type Options struct {
+	Format  string            /* "csv" or "json", taken from the file extension when empty */
+	Dim     int               /* 2 or 3, z and vz are ignored in 2D */
+	Columns map[string]string /* field to the column name or JSON key holding it, a 1-based column number in CSV */
+	Header  bool              /* the first CSV line names the columns */
+}
+
+/* an invalid value or record, with the line of the file it was found on */
+type LineError struct {
+	Path  string
+	Line  int
+	Field string /* empty for errors about the whole record */
+	Err   error
+}
+
+func (e *LineError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s: %v", e.Path, e.Line, e.Field, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+/* parse a column mapping of the form "x=px,y=py,mass=m" */
+func ParseColumns(mapping string) (map[string]string, error) {
+	columns := make(map[string]string)
+	if strings.TrimSpace(mapping) == "" {
+		return columns, nil
+	}
+	for _, pair := range strings.Split(mapping, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field, column = strings.ToLower(strings.TrimSpace(field)), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("column mapping %q is not of the form field=column", pair)
+		}
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown field %q in column mapping (available: %s)", field, strings.Join(Fields, ", "))
+		}
+		columns[field] = column
+	}
+	return columns, nil
+}
+
+/* read the particles of a CSV or JSON file */
+func Load(path string, opts Options) ([]nbody.Particle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	for field := range opts.Columns {
+		if !isField(field) {
+			return nil, fmt.Errorf("unknown field %q in column mapping (available: %s)", field, strings.Join(Fields, ", "))
+		}
+	}
+	switch format {
+	case "csv":
+		return ReadCSV(file, path, opts)
+	case "json":
+		return ReadJSON(file, path, opts)
+	default:
+		return nil, fmt.Errorf("%s: unknown initial conditions format %q (available: csv, json)", path, format)
+	}
+}
+
+/* read particles from CSV, name is used in error messages */
+func ReadCSV(r io.Reader, name string, opts Options) ([]nbody.Particle, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	/* column index of every field the file provides, -1 when missing */
+	index := make(map[string]int)
+	headerLine := 1
+	if opts.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: no header line", name)
+		}
+		if err != nil {
+			return nil, csvError(name, err)
+		}
+		headerLine, _ = reader.FieldPos(0)
+		for _, field := range Fields {
+			index[field] = -1
+			column := columnOf(field, opts)
+			for i, title := range header {
+				if strings.EqualFold(strings.TrimSpace(title), column) {
+					index[field] = i
+				}
+			}
+			if n, err := strconv.Atoi(column); err == nil && index[field] < 0 && n >= 1 {
+				index[field] = n - 1
+			}
+			if _, mapped := opts.Columns[field]; mapped && index[field] < 0 {
+				return nil, &LineError{Path: name, Line: headerLine, Field: field, Err: fmt.Errorf("no column %q", column)}
+			}
+		}
+	} else {
+		i := 0
+		for _, field := range Fields {
+			index[field] = -1
+			if (field == "z" || field == "vz") && opts.Dim != 3 {
+				continue
+			}
+			index[field] = i
+			i++
+			if column, ok := opts.Columns[field]; ok {
+				n, err := strconv.Atoi(column)
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("%s: column %q of %s must be a number when the file has no header", name, column, field)
+				}
+				index[field] = n - 1
+			}
+		}
+	}
+	if err := checkRequired(name, headerLine, opts.Dim, func(field string) bool { return index[field] >= 0 }); err != nil {
+		return nil, err
+	}
+
+	var particleArray []nbody.Particle
+	var lines []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvError(name, err)
+		}
+		line, _ := reader.FieldPos(0)
+		value := func(field string) (string, bool) {
+			i := index[field]
+			if i < 0 || i >= len(record) {
+				return "", false
+			}
+			return strings.TrimSpace(record[i]), true
+		}
+		p, lineErr := particle(value, len(particleArray), opts.Dim)
+		if lineErr != nil {
+			lineErr.Path, lineErr.Line = name, line
+			return nil, lineErr
+		}
+		particleArray = append(particleArray, p)
+		lines = append(lines, line)
+	}
+	return particleArray, checkIDs(name, particleArray, lines)
+}
+
+/* read particles from JSON, name is used in error messages */
+func ReadJSON(r io.Reader, name string, opts Options) ([]nbody.Particle, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := openParticles(decoder); err != nil {
+		return nil, jsonError(name, data, decoder, err)
+	}
+
+	var particleArray []nbody.Particle
+	var lines []int
+	for decoder.More() {
+		line := lineAt(data, skipSeparators(data, int(decoder.InputOffset())))
+		var object map[string]json.RawMessage
+		if err := decoder.Decode(&object); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				return nil, &LineError{Path: name, Line: line, Err: errors.New("particle is not a JSON object")}
+			}
+			return nil, jsonError(name, data, decoder, err)
+		}
+		keys := make(map[string]json.RawMessage, len(object))
+		for key, raw := range object {
+			keys[strings.ToLower(key)] = raw
+		}
+		if len(particleArray) == 0 {
+			if err := checkRequired(name, line, opts.Dim, func(field string) bool {
+				_, ok := keys[strings.ToLower(columnOf(field, opts))]
+				return ok
+			}); err != nil {
+				return nil, err
+			}
+		}
+		value := func(field string) (string, bool) {
+			raw, ok := keys[strings.ToLower(columnOf(field, opts))]
+			if !ok || string(raw) == "null" {
+				return "", false
+			}
+			var s string
+			if json.Unmarshal(raw, &s) == nil {
+				return s, true
+			}
+			return string(raw), true
+		}
+		p, lineErr := particle(value, len(particleArray), opts.Dim)
+		if lineErr != nil {
+			lineErr.Path, lineErr.Line = name, line
+			return nil, lineErr
+		}
+		particleArray = append(particleArray, p)
+		lines = append(lines, line)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, jsonError(name, data, decoder, err)
+	}
+	return particleArray, checkIDs(name, particleArray, lines)
+}
+
+/* advance the decoder into the array of particles, at the top level or under the "particles" key */
+func openParticles(decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == json.Delim('[') {
+		return nil
+	}
+	if token != json.Delim('{') {
+		return errExpectedParticles
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key == "particles" {
+			if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+				if err == nil {
+					err = errExpectedParticles
+				}
+				return err
+			}
+			return nil
+		}
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return err
+		}
+	}
+	return errExpectedParticles
+}
+
+var errExpectedParticles = errors.New("expected an array of particles or an object with a \"particles\" array")
+
+/* build particle number i from the values of its fields, the error has no position yet */
+func particle(value func(field string) (string, bool), i int, dim int) (nbody.Particle, *LineError) {
+	var v [7]float64
+	for j, field := range Fields[:7] {
+		if (field == "z" || field == "vz") && dim != 3 {
+			continue
+		}
+		s, ok := value(field)
+		if !ok || s == "" {
+			if field == "vx" || field == "vy" || field == "vz" {
+				continue
+			}
+			return nbody.Particle{}, &LineError{Field: field, Err: errors.New("missing value")}
+		}
+		x, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nbody.Particle{}, &LineError{Field: field, Err: fmt.Errorf("%q is not a number", s)}
+		}
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nbody.Particle{}, &LineError{Field: field, Err: fmt.Errorf("%s is not finite", s)}
+		}
+		v[j] = x
+	}
+	if v[6] <= 0 {
+		return nbody.Particle{}, &LineError{Field: "mass", Err: fmt.Errorf("%g is not positive", v[6])}
+	}
+
+	var p nbody.Particle
+	p.SetPosition(v[0], v[1], v[2])
+	p.SetVelocity(v[3], v[4], v[5])
+	p.Mass = v[6]
+	p.ID = uint64(i)
+	if s, ok := value("id"); ok && s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nbody.Particle{}, &LineError{Field: "id", Err: fmt.Errorf("%q is not a non-negative integer", s)}
+		}
+		p.ID = id
+	}
+	typeLabel, _ := value("type")
+	group, _ := value("group")
+	if typeLabel != "" || group != "" {
+		p.Meta = &nbody.ParticleMeta{Type: typeLabel, Group: group}
+	}
+	return p, nil
+}
+
+/* the fields without a default must be present */
+func checkRequired(name string, line int, dim int, present func(field string) bool) error {
+	required := []string{"x", "y", "mass"}
+	if dim == 3 {
+		required = []string{"x", "y", "z", "mass"}
+	}
+	for _, field := range required {
+		if !present(field) {
+			return &LineError{Path: name, Line: line, Field: field, Err: errors.New("no such column")}
+		}
+	}
+	return nil
+}
+
+/* IDs must be unique, lines holds the line of every particle */
+func checkIDs(name string, particleArray []nbody.Particle, lines []int) error {
+	seen := make(map[uint64]int, len(particleArray))
+	for i := range particleArray {
+		id := particleArray[i].ID
+		if first, ok := seen[id]; ok {
+			return &LineError{Path: name, Line: lines[i], Field: "id", Err: fmt.Errorf("%d is already used on line %d", id, lines[first])}
+		}
+		seen[id] = i
+	}
+	return nil
+}
+
+func columnOf(field string, opts Options) string {
+	if column, ok := opts.Columns[field]; ok {
+		return column
+	}
+	return field
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func csvError(name string, err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &LineError{Path: name, Line: parseErr.Line, Err: parseErr.Err}
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+/* error of the decoder with the line it stopped on */
+func jsonError(name string, data []byte, decoder *json.Decoder, err error) error {
+	offset := int(decoder.InputOffset())
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset = int(syntaxErr.Offset)
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &LineError{Path: name, Line: lineAt(data, offset), Err: err}
+}
+
+/* offset of the first byte at or after offset that is not white space or a comma */
+func skipSeparators(data []byte, offset int) int {
+	for offset < len(data) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+/* 1-based line of the byte at offset */
+func lineAt(data []byte, offset int) int {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
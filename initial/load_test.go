@@ -0,0 +1,97 @@
+package initial
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	content := "# three bodies\npx,py,vx,vy,m,kind,id\n0,0,0,0,10,star,7\n1,0,0,3,0.1,planet,8\n-1,0,0,-3,0.1,,9\n"
+	columns, err := ParseColumns("x=px, y=py, mass=m, type=kind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	particleArray, err := ReadCSV(strings.NewReader(content), "bodies.csv", Options{Dim: 2, Columns: columns, Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(particleArray) != 3 {
+		t.Fatalf("%d particles read", len(particleArray))
+	}
+	p := &particleArray[1]
+	x, y, _ := p.Position()
+	_, vy, _ := p.Velocity()
+	if x != 1 || y != 0 || vy != 3 || p.Mass != 0.1 || p.ID != 8 || p.Meta == nil || p.Meta.Type != "planet" {
+		t.Fatalf("second particle read as %+v", p.State())
+	}
+	if particleArray[2].Meta != nil {
+		t.Fatal("particle without labels got metadata")
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	content := `{"name": "demo", "particles": [
+		{"x": 0, "y": 0, "z": 1, "mass": 10, "group": "sun"},
+		{"X": 1, "y": 0, "z": 0, "vz": 2, "mass": 0.1}
+	]}`
+	particleArray, err := ReadJSON(strings.NewReader(content), "bodies.json", Options{Dim: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(particleArray) != 2 || particleArray[0].Meta.Group != "sun" || particleArray[1].ID != 1 {
+		t.Fatalf("read %d particles", len(particleArray))
+	}
+	if _, _, vz := particleArray[1].Velocity(); vz != 2 {
+		t.Fatalf("vz read as %g", vz)
+	}
+}
+
+/* every invalid file names the line of the problem */
+func TestLineErrors(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		content string
+		opts    Options
+		line    int
+		message string
+	}{
+		{"bad.csv", "x,y,vx,vy,mass\n0,0,0,0,10\n1,0,abc,3,0.1\n", Options{Dim: 2, Header: true}, 3, `vx: "abc" is not a number`},
+		{"short.csv", "x,y,vx,vy,mass\n0,0,0,0,10\n\n1,0,3,0.1\n", Options{Dim: 2, Header: true}, 4, "wrong number of fields"},
+		{"nan.csv", "x,y,mass\n0,NaN,1\n", Options{Dim: 2, Header: true}, 2, "y: NaN is not finite"},
+		{"mass.csv", "# comment\nx,y,mass\n0,0,-1\n", Options{Dim: 2, Header: true}, 3, "mass: -1 is not positive"},
+		{"nomass.csv", "# comment\nx,y\n0,0\n", Options{Dim: 2, Header: true}, 2, "mass: no such column"},
+		{"noz.csv", "x,y,mass\n0,0,1\n", Options{Dim: 3, Header: true}, 1, "z: no such column"},
+		{"mapped.csv", "x,y,mass\n0,0,1\n", Options{Dim: 2, Header: true, Columns: map[string]string{"vx": "velx"}}, 1, `vx: no column "velx"`},
+		{"dup.csv", "0,0,0,0,1,5\n1,1,0,0,1,5\n", Options{Dim: 2}, 2, "id: 5 is already used on line 1"},
+		{"mass.json", "[\n  {\"x\": 0, \"y\": 0, \"mass\": 1},\n  {\"x\": 1, \"y\": 0,\n   \"mass\": -0.1}\n]", Options{Dim: 2}, 3, "mass: -0.1 is not positive"},
+		{"dup.json", "[\n  {\"x\": 0, \"y\": 0, \"mass\": 1, \"id\": 1},\n  {\"x\": 1, \"y\": 0, \"mass\": 1, \"id\": 1}\n]", Options{Dim: 2}, 3, "id: 1 is already used on line 2"},
+		{"syntax.json", "[\n  {\"x\": 0, \"y\": 0, \"mass\": 1},\n  {\"x\": 1 \"y\": 0, \"mass\": 1}\n]", Options{Dim: 2}, 3, "invalid character"},
+		{"string.json", "[\n  {\"x\": 0, \"y\": \"north\", \"mass\": 1}\n]", Options{Dim: 2}, 2, `y: "north" is not a number`},
+		{"object.json", "[\n  1,\n  2\n]", Options{Dim: 2}, 2, "particle is not a JSON object"},
+		{"top.json", "\n\n\"particles\"", Options{Dim: 2}, 3, "expected an array of particles"},
+	} {
+		var err error
+		if strings.HasSuffix(c.name, ".csv") {
+			_, err = ReadCSV(strings.NewReader(c.content), c.name, c.opts)
+		} else {
+			_, err = ReadJSON(strings.NewReader(c.content), c.name, c.opts)
+		}
+		var lineErr *LineError
+		if !errors.As(err, &lineErr) {
+			t.Errorf("%s: expected an error with a line, got %v", c.name, err)
+			continue
+		}
+		if lineErr.Line != c.line || !strings.Contains(err.Error(), c.message) || !strings.HasPrefix(err.Error(), c.name+":") {
+			t.Errorf("%s: got %q, expected line %d with %q", c.name, err, c.line, c.message)
+		}
+	}
+}
+
+func TestParseColumns(t *testing.T) {
+	for _, mapping := range []string{"x", "x=", "speed=v"} {
+		if _, err := ParseColumns(mapping); err == nil {
+			t.Errorf("mapping %q accepted", mapping)
+		}
+	}
+}
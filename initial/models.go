@@ -0,0 +1,252 @@
+package initial
+
+import (
+	"math"
+	"proj3/nbody"
+)
+
+/* sampling of Aarseth, Henon and Wielen (1974), cut off at 99.9% of the mass */
+func plummer(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	a := p.Radius
+	return sphere(p.N, p.Mass/float64(p.N), r, func() (float64, float64) {
+		x := 0.0
+		for !(x > 0 && x < 0.999) {
+			x = r.Float64()
+		}
+		rad := a / math.Sqrt(math.Pow(x, -2.0/3.0)-1)
+
+		/* speed in units of the escape speed from g(q) = q^2 (1 - q^2)^(7/2) by rejection, g < 0.1 */
+		q := 0.0
+		for {
+			q = r.Float64()
+			if 0.1*r.Float64() < q*q*math.Pow(1-q*q, 3.5) {
+				break
+			}
+		}
+		escape := math.Sqrt(2*p.G*p.Mass) * math.Pow(rad*rad+a*a, -0.25)
+		return rad, q * escape
+	})
+}
+
+func hernquist(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	return sphere(p.N, p.Mass/float64(p.N), r, func() (float64, float64) {
+		return hernquistDraw(p.Radius, p.Mass, p.G, r)
+	})
+}
+
+/*
+radius and speed of a particle of a Hernquist sphere with scale radius a and mass m. The radius inverts
+M(r) = m r^2 / (r + a)^2 cut off at 99% of the mass, the speed is drawn by rejection from v^2 f(E) with the
+isotropic distribution function of Hernquist (1990).
+*/
+func hernquistDraw(a float64, m float64, G float64, r *nbody.Rand) (float64, float64) {
+	x := 0.0
+	for !(x > 0 && x < 0.99) {
+		x = r.Float64()
+	}
+	s := math.Sqrt(x)
+	rad := a * s / (1 - s)
+
+	psi := G * m / (rad + a)
+	escape := math.Sqrt(2 * psi)
+	density := func(v float64) float64 {
+		return v * v * hernquistDF((psi-v*v/2)*a/(G*m))
+	}
+	/* the density vanishes at 0 and at the escape speed with a single peak in between */
+	const grid = 100
+	best := 1
+	for i := 2; i < grid; i++ {
+		if density(escape*float64(i)/grid) > density(escape*float64(best)/grid) {
+			best = i
+		}
+	}
+	lo, hi := escape*float64(best-1)/grid, escape*float64(best+1)/grid
+	for i := 0; i < 40; i++ {
+		m1, m2 := lo+(hi-lo)/3, hi-(hi-lo)/3
+		if density(m1) < density(m2) {
+			lo = m1
+		} else {
+			hi = m2
+		}
+	}
+	bound := 1.1 * density((lo+hi)/2)
+	for {
+		v := escape * r.Float64()
+		if bound*r.Float64() < density(v) {
+			return rad, v
+		}
+	}
+}
+
+/* isotropic Hernquist distribution function of the binding energy in units of G m / a, up to a constant */
+func hernquistDF(e float64) float64 {
+	if e <= 0 {
+		return 0
+	}
+	if e >= 1 {
+		return math.Inf(1)
+	}
+	q := math.Sqrt(e)
+	f := (3*math.Asin(q) + q*math.Sqrt(1-q*q)*(1-2*q*q)*(8*q*q*q*q-8*q*q-3)) / math.Pow(1-q*q, 2.5)
+	return math.Max(f, 0)
+}
+
+/*
+central body with 99% of the mass and a disk of uniform surface density between R / 10 and R, on circular
+orbits around the central body and the disk mass inside them
+*/
+func keplerDisk(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	particleArray := make([]nbody.Particle, p.N)
+	central := p.Mass
+	if p.N > 1 {
+		central = 0.99 * p.Mass
+	}
+	particleArray[0].Mass = central
+	particleArray[0].Meta = &nbody.ParticleMeta{Type: "central"}
+
+	disk := p.Mass - central
+	inner, outer := p.Radius/10, p.Radius
+	diskMeta := &nbody.ParticleMeta{Type: "disk"}
+	for i := 1; i < p.N; i++ {
+		area := r.Float64()
+		rad := math.Sqrt(inner*inner + area*(outer*outer-inner*inner))
+		speed := math.Sqrt(p.G * (central + area*disk) / rad)
+		circularOrbit(&particleArray[i], rad, 0, speed, r)
+		particleArray[i].Mass = disk / float64(p.N-1)
+		particleArray[i].Meta = diskMeta
+	}
+	return particleArray, nil
+}
+
+/*
+exponential disk with scale length R cut off at 10 R, and a Hernquist bulge with scale radius R / 5 holding
+20% of the particles and of the mass. Disk particles are on circular orbits in the potential of a razor thin
+disk (Freeman 1970) and of the bulge, with a sech^2 profile of scale height R / 10 in 3D. Bulge velocities are drawn from the
+equilibrium of the bulge alone; in 2D the bulge is flattened into the plane of the disk.
+*/
+func galaxy(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	particleArray := make([]nbody.Particle, p.N)
+	m := p.Mass / float64(p.N)
+	nBulge := int(math.Round(0.2 * float64(p.N)))
+	bulgeMass, diskMass := m*float64(nBulge), m*float64(p.N-nBulge)
+	scale, bulgeScale, height := p.Radius, p.Radius/5, p.Radius/10
+
+	bulgeMeta := &nbody.ParticleMeta{Type: "bulge"}
+	for i := 0; i < nBulge; i++ {
+		rad, speed := hernquistDraw(bulgeScale, bulgeMass, p.G, r)
+		x, y, z := isotropic(r)
+		vx, vy, vz := isotropic(r)
+		if p.Dim != 3 {
+			z, vz = 0, 0
+		}
+		particleArray[i].SetPosition(rad*x, rad*y, rad*z)
+		particleArray[i].SetVelocity(speed*vx, speed*vy, speed*vz)
+		particleArray[i].Mass = m
+		particleArray[i].Meta = bulgeMeta
+	}
+
+	diskMeta := &nbody.ParticleMeta{Type: "disk"}
+	for i := nBulge; i < p.N; i++ {
+		/* R / scale follows x e^-x, the sum of two exponential variates */
+		x := math.Inf(1)
+		for x > 10 {
+			x = -math.Log((1 - r.Float64()) * (1 - r.Float64()))
+		}
+		rad := x * scale
+		y := x / 2
+		bulge := p.G * bulgeMass * rad / ((rad + bulgeScale) * (rad + bulgeScale))
+		disk := 2 * p.G * diskMass / scale * y * y * (besselI0(y)*besselK0(y) - besselI1(y)*besselK1(y))
+		z := 0.0
+		if p.Dim == 3 {
+			u := r.Float64()
+			for u == 0 {
+				u = r.Float64()
+			}
+			z = height * math.Atanh(2*u-1)
+		}
+		circularOrbit(&particleArray[i], rad, z, math.Sqrt(bulge+disk), r)
+		particleArray[i].Mass = m
+		particleArray[i].Meta = diskMeta
+	}
+	return particleArray, nil
+}
+
+/* uniform sphere at rest, whose collapse takes a free fall time of pi / 2 sqrt(R^3 / (2 G M)) */
+func coldCollapse(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	return sphere(p.N, p.Mass/float64(p.N), r, func() (float64, float64) {
+		return p.Radius * math.Cbrt(r.Float64()), 0
+	})
+}
+
+/* cell centers of the smallest lattice with at least N cells, filled in row order, at rest */
+func lattice(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	side := 1
+	for int(math.Pow(float64(side), float64(p.Dim))) < p.N {
+		side++
+	}
+	spacing := 2 * p.Radius / float64(side)
+	coordinate := func(i int) float64 {
+		return -p.Radius + (float64(i)+0.5)*spacing
+	}
+	particleArray := make([]nbody.Particle, p.N)
+	for i := range particleArray {
+		z := 0.0
+		if p.Dim == 3 {
+			z = coordinate(i / (side * side))
+		}
+		particleArray[i].SetPosition(coordinate(i%side), coordinate(i/side%side), z)
+		particleArray[i].Mass = p.Mass / float64(p.N)
+	}
+	return particleArray, nil
+}
+
+/* place q at a random angle on the counterclockwise circular orbit of the given radius in the plane z */
+func circularOrbit(q *nbody.Particle, rad float64, z float64, speed float64, r *nbody.Rand) {
+	phi := 2 * math.Pi * r.Float64()
+	sin, cos := math.Sincos(phi)
+	q.SetPosition(rad*cos, rad*sin, z)
+	q.SetVelocity(-speed*sin, speed*cos, 0)
+}
+
+/* modified Bessel functions by the polynomial approximations of Abramowitz and Stegun 9.8.1 to 9.8.8 */
+func besselI0(x float64) float64 {
+	if x < 3.75 {
+		t := x * x / (3.75 * 3.75)
+		return 1 + t*(3.5156229+t*(3.0899424+t*(1.2067492+t*(0.2659732+t*(0.0360768+t*0.0045813)))))
+	}
+	t := 3.75 / x
+	return math.Exp(x) / math.Sqrt(x) * (0.39894228 + t*(0.01328592+t*(0.00225319+t*(-0.00157565+t*(0.00916281+
+		t*(-0.02057706+t*(0.02635537+t*(-0.01647633+t*0.00392377))))))))
+}
+
+func besselI1(x float64) float64 {
+	if x < 3.75 {
+		t := x * x / (3.75 * 3.75)
+		return x * (0.5 + t*(0.87890594+t*(0.51498869+t*(0.15084934+t*(0.02658733+t*(0.00301532+t*0.00032411))))))
+	}
+	t := 3.75 / x
+	return math.Exp(x) / math.Sqrt(x) * (0.39894228 + t*(-0.03988024+t*(-0.00362018+t*(0.00163801+t*(-0.01031555+
+		t*(0.02282967+t*(-0.02895312+t*(0.01787654-t*0.00420059))))))))
+}
+
+func besselK0(x float64) float64 {
+	if x <= 2 {
+		t := x * x / 4
+		return -math.Log(x/2)*besselI0(x) + (-0.57721566 + t*(0.42278420+t*(0.23069756+t*(0.03488590+t*(0.00262698+
+			t*(0.00010750+t*0.0000074))))))
+	}
+	t := 2 / x
+	return math.Exp(-x) / math.Sqrt(x) * (1.25331414 + t*(-0.07832358+t*(0.02189568+t*(-0.01062446+t*(0.00587872+
+		t*(-0.00251540+t*0.00053208))))))
+}
+
+func besselK1(x float64) float64 {
+	if x <= 2 {
+		t := x * x / 4
+		return math.Log(x/2)*besselI1(x) + (1+t*(0.15443144+t*(-0.67278579+t*(-0.18156897+t*(-0.01919402+
+			t*(-0.00110404-t*0.00004686))))))/x
+	}
+	t := 2 / x
+	return math.Exp(-x) / math.Sqrt(x) * (1.25331414 + t*(0.23498619+t*(-0.03655620+t*(0.01504268+t*(-0.00780353+
+		t*(0.00325614-t*0.00068245))))))
+}
@@ -0,0 +1,284 @@
+package initial
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"proj3/nbody"
+)
+
+/* one system of a scenario, placed by rotating it about its origin and then shifting it */
+type System struct {
+	Label     string /* group of the system's particles, kept from their metadata when empty */
+	Particles []nbody.Particle
+	Offset    [3]float64 /* position of the system's origin */
+	Velocity  [3]float64 /* bulk velocity added to every particle */
+	Rotation  [3]float64 /* angles in radians about the x, y and z axes, applied in that order */
+	KeepIDs   bool       /* the particles keep their IDs, e.g. those of a loaded file, instead of being numbered */
+}
+
+/* several systems combined into the particles of one run */
+type Scenario struct {
+	Dim     int
+	Systems []System
+}
+
+func NewScenario(dim int) *Scenario {
+	return &Scenario{Dim: dim}
+}
+
+/* add a system, the particles are copied by Build and may be reused */
+func (s *Scenario) Add(system System) *Scenario {
+	s.Systems = append(s.Systems, system)
+	return s
+}
+
+/*
+the particles of all systems in the order they were added, placed and labeled. Particles of systems with
+KeepIDs keep their IDs, which must be unique across the scenario; the others are numbered from 0 in order,
+skipping the kept IDs. In 2D systems can only be rotated about the z axis and moved within the plane.
+*/
+func (s *Scenario) Build() ([]nbody.Particle, error) {
+	if len(s.Systems) == 0 {
+		return nil, errors.New("scenario has no systems")
+	}
+	for i, system := range s.Systems {
+		if len(system.Particles) == 0 {
+			return nil, fmt.Errorf("system %d (%s) has no particles", i, system.Label)
+		}
+		if s.Dim != 3 && (system.Rotation[0] != 0 || system.Rotation[1] != 0 || system.Offset[2] != 0 || system.Velocity[2] != 0) {
+			return nil, fmt.Errorf("system %d (%s) leaves the plane of a 2D scenario", i, system.Label)
+		}
+	}
+
+	kept := make(map[uint64]int) /* ID to the system keeping it */
+	for i, system := range s.Systems {
+		if !system.KeepIDs {
+			continue
+		}
+		for j := range system.Particles {
+			id := system.Particles[j].ID
+			if other, ok := kept[id]; ok {
+				return nil, fmt.Errorf("system %d (%s): id %d is already used by system %d (%s)", i, system.Label, id, other, s.Systems[other].Label)
+			}
+			kept[id] = i
+		}
+	}
+
+	var particleArray []nbody.Particle
+	next := uint64(0)
+	for _, system := range s.Systems {
+		rotate := rotation(system.Rotation)
+		labels := make(map[*nbody.ParticleMeta]*nbody.ParticleMeta)
+		for i := range system.Particles {
+			p := system.Particles[i]
+			x, y, z := rotate(p.Position())
+			vx, vy, vz := rotate(p.Velocity())
+			p.SetPosition(x+system.Offset[0], y+system.Offset[1], z+system.Offset[2])
+			p.SetVelocity(vx+system.Velocity[0], vy+system.Velocity[1], vz+system.Velocity[2])
+			p.Node = nil
+			if !system.KeepIDs {
+				for _, ok := kept[next]; ok; _, ok = kept[next] {
+					next++
+				}
+				p.ID = next
+				next++
+			}
+			if system.Label != "" {
+				/* one relabeled copy per distinct metadata, so that particles keep sharing it */
+				meta, ok := labels[p.Meta]
+				if !ok {
+					meta = &nbody.ParticleMeta{Group: system.Label}
+					if p.Meta != nil {
+						meta.Type, meta.Payload = p.Meta.Type, p.Meta.Payload
+					}
+					labels[p.Meta] = meta
+				}
+				p.Meta = meta
+			}
+			particleArray = append(particleArray, p)
+		}
+	}
+	return particleArray, nil
+}
+
+/* rotation by the angles about x, then y, then z */
+func rotation(angles [3]float64) func(float64, float64, float64) (float64, float64, float64) {
+	sx, cx := math.Sincos(angles[0])
+	sy, cy := math.Sincos(angles[1])
+	sz, cz := math.Sincos(angles[2])
+	return func(x float64, y float64, z float64) (float64, float64, float64) {
+		y, z = cx*y-sx*z, sx*y+cx*z
+		x, z = cy*x+sy*z, -sy*x+cy*z
+		x, y = cz*x-sz*y, sz*x+cz*y
+		return x, y, z
+	}
+}
+
+/* orbit of a two galaxy encounter */
+type Encounter struct {
+	Separation  float64 /* initial distance between the centers */
+	Pericenter  float64 /* closest approach of the parabolic orbit of the centers */
+	MassRatio   float64 /* mass of the second galaxy over the first, particles are split in the same ratio */
+	Inclination float64 /* tilt of the second disk about the x axis in radians, 3D only */
+}
+
+/* encounter from 10 R with pericenter 2 R of two equal galaxies, the second tilted by 45 degrees in 3D */
+func DefaultEncounter(p Params) Encounter {
+	e := Encounter{Separation: 10 * p.Radius, Pericenter: 2 * p.Radius, MassRatio: 1}
+	if p.Dim == 3 {
+		e.Inclination = math.Pi / 4
+	}
+	return e
+}
+
+/*
+two galaxy models with p.N particles and mass p.Mass between them, labeled A and B, approaching each other on
+a parabolic orbit in the xy plane in the center of mass frame. Both disks rotate in the sense of the orbit
+before the second is tilted.
+*/
+func TwoGalaxies(p Params, e Encounter) (*Scenario, error) {
+	if !(e.Separation > 0) || !(e.Pericenter > 0) || e.Pericenter >= e.Separation {
+		return nil, fmt.Errorf("pericenter must be positive and smaller than the separation, got %g and %g", e.Pericenter, e.Separation)
+	}
+	if !(e.MassRatio > 0) || math.IsInf(e.MassRatio, 0) {
+		return nil, fmt.Errorf("mass ratio must be positive and finite, got %g", e.MassRatio)
+	}
+	if p.N < 2 {
+		return nil, fmt.Errorf("two galaxies need at least 2 particles, got %d", p.N)
+	}
+	g := Generator{Name: "galaxy", Dims: []int{2, 3}, generate: galaxy} /* not looked up, the list of generators includes this preset */
+	fractionB := e.MassRatio / (1 + e.MassRatio)
+	nB := int(math.Round(fractionB * float64(p.N)))
+	nB = int(math.Min(math.Max(float64(nB), 1), float64(p.N-1)))
+	r := p.Rand
+	if r == nil {
+		r = nbody.NewRand(p.Seed)
+	}
+	pa, pb := p, p
+	pa.N, pa.Mass, pa.Rand = p.N-nB, p.Mass*float64(p.N-nB)/float64(p.N), r
+	pb.N, pb.Mass, pb.Rand = nB, p.Mass*float64(nB)/float64(p.N), r
+	a, err := g.Generate(pa)
+	if err != nil {
+		return nil, err
+	}
+	b, err := g.Generate(pb)
+	if err != nil {
+		return nil, err
+	}
+
+	/* relative orbit of B around A: parabolic speed at the separation, tangential part from the pericenter */
+	d := e.Separation
+	speed := math.Sqrt(2 * p.G * p.Mass / d)
+	tangential := math.Sqrt(2*p.G*p.Mass*e.Pericenter) / d
+	radial := -math.Sqrt(speed*speed - tangential*tangential)
+	fa, fb := pa.Mass/p.Mass, pb.Mass/p.Mass
+
+	s := NewScenario(p.Dim)
+	s.Add(System{Label: "A", Particles: a, Offset: [3]float64{-fb * d, 0, 0}, Velocity: [3]float64{-fb * radial, -fb * tangential, 0}})
+	s.Add(System{Label: "B", Particles: b, Offset: [3]float64{fa * d, 0, 0}, Velocity: [3]float64{fa * radial, fa * tangential, 0},
+		Rotation: [3]float64{e.Inclination, 0, 0}})
+	return s, nil
+}
+
+/* the two galaxy encounter with its defaults as a generator */
+func twoGalaxies(p Params, r *nbody.Rand) ([]nbody.Particle, error) {
+	p.Rand = r
+	s, err := TwoGalaxies(p, DefaultEncounter(p))
+	if err != nil {
+		return nil, err
+	}
+	return s.Build()
+}
+
+/* a system of a scenario file */
+type systemSpec struct {
+	Label    string            `json:"label"`
+	Model    string            `json:"model"`
+	File     string            `json:"file"`
+	Columns  map[string]string `json:"columns"`
+	Header   *bool             `json:"header"`
+	N        int               `json:"n"`
+	Radius   float64           `json:"radius"`
+	Mass     float64           `json:"mass"`
+	Seed     *int64            `json:"seed"`
+	Offset   [3]float64        `json:"offset"`
+	Velocity [3]float64        `json:"velocity"`
+	Rotation [3]float64        `json:"rotation"` /* degrees */
+	Renumber bool              `json:"renumber"` /* number the particles of a file instead of keeping their IDs */
+}
+
+/*
+read a scenario from a JSON file of the form {"systems": [...]}. Every system has a "model" with "n" and
+optionally "radius", "mass" and "seed", or a particle "file" with optional "columns", "header" and "renumber",
+and may have a "label", an "offset", a "velocity" and a "rotation" in degrees. Missing radius and mass are taken
+from defaults, models without a seed draw from defaults.Rand. Files are found relative to the directory of the
+scenario file and keep their particle IDs unless "renumber" is true.
+*/
+func LoadScenario(path string, defaults Params) (*Scenario, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	var spec struct {
+		Systems []systemSpec `json:"systems"`
+	}
+	if err := decoder.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s := NewScenario(defaults.Dim)
+	for i, system := range spec.Systems {
+		particleArray, err := system.particles(filepath.Dir(path), defaults)
+		if err != nil {
+			return nil, fmt.Errorf("%s: system %d (%s): %w", path, i, system.Label, err)
+		}
+		var rotation [3]float64
+		for j, degrees := range system.Rotation {
+			rotation[j] = degrees * math.Pi / 180
+		}
+		s.Add(System{Label: system.Label, Particles: particleArray, Offset: system.Offset, Velocity: system.Velocity, Rotation: rotation,
+			KeepIDs: system.File != "" && !system.Renumber})
+	}
+	return s, nil
+}
+
+/* particles of the system, with a relative file path taken from dir */
+func (spec systemSpec) particles(dir string, defaults Params) ([]nbody.Particle, error) {
+	if (spec.Model == "") == (spec.File == "") {
+		return nil, errors.New("exactly one of model and file must be given")
+	}
+	if spec.File != "" {
+		path := spec.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		header := spec.Header == nil || *spec.Header
+		return Load(path, Options{Dim: defaults.Dim, Columns: spec.Columns, Header: header})
+	}
+	if spec.Renumber {
+		return nil, errors.New("renumber only applies to a file")
+	}
+	generator, err := GetGenerator(spec.Model)
+	if err != nil {
+		return nil, err
+	}
+	p := defaults
+	p.N = spec.N
+	if spec.Radius != 0 {
+		p.Radius = spec.Radius
+	}
+	if spec.Mass != 0 {
+		p.Mass = spec.Mass
+	}
+	if spec.Seed != nil {
+		p.Seed, p.Rand = *spec.Seed, nil
+	}
+	return generator.Generate(p)
+}
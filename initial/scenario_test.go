@@ -0,0 +1,212 @@
+package initial
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"proj3/nbody"
+	"strings"
+	"testing"
+)
+
+func near(a [3]float64, b [3]float64) bool {
+	return math.Abs(a[0]-b[0])+math.Abs(a[1]-b[1])+math.Abs(a[2]-b[2]) < 1e-12
+}
+
+/* a quarter turn about each axis, applied about x first, then y, then z, before the shift */
+func TestScenarioRotationOrder(t *testing.T) {
+	particles := make([]nbody.Particle, 1)
+	particles[0].SetPosition(1, 2, 3)
+	particles[0].SetVelocity(0, 1, 0)
+	particles[0].Mass = 1
+	s := NewScenario(3).Add(System{Particles: particles, Offset: [3]float64{10, 0, 0}, Velocity: [3]float64{0, 0, 5},
+		Rotation: [3]float64{math.Pi / 2, math.Pi / 2, math.Pi / 2}})
+	particleArray, err := s.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y, z := particleArray[0].Position()
+	vx, vy, vz := particleArray[0].Velocity()
+	/* (1, 2, 3) goes to (1, -3, 2) about x, (2, -3, -1) about y and (3, 2, -1) about z */
+	if !near([3]float64{x, y, z}, [3]float64{13, 2, -1}) || !near([3]float64{vx, vy, vz}, [3]float64{0, 1, 5}) {
+		t.Fatalf("placed at (%g, %g, %g) with velocity (%g, %g, %g)", x, y, z, vx, vy, vz)
+	}
+	if x, y, z := particles[0].Position(); x != 1 || y != 2 || z != 3 {
+		t.Fatal("Build moved the particles it was given")
+	}
+}
+
+func TestScenarioPlane(t *testing.T) {
+	particles := make([]nbody.Particle, 1)
+	particles[0].Mass = 1
+	for _, system := range []System{
+		{Rotation: [3]float64{0.1, 0, 0}},
+		{Rotation: [3]float64{0, 0.1, 0}},
+		{Offset: [3]float64{0, 0, 1}},
+		{Velocity: [3]float64{0, 0, 1}},
+	} {
+		system.Label, system.Particles = "tilted", particles
+		if _, err := NewScenario(2).Add(system).Build(); err == nil || !strings.Contains(err.Error(), "leaves the plane") {
+			t.Errorf("%+v: %v", system, err)
+		}
+	}
+	particleArray, err := NewScenario(2).Add(System{Particles: particles, Rotation: [3]float64{0, 0, 1}, Offset: [3]float64{1, 2, 0}}).Build()
+	if err != nil || len(particleArray) != 1 {
+		t.Fatalf("rotation in the plane: %v", err)
+	}
+	if _, err := NewScenario(2).Build(); err == nil {
+		t.Error("empty scenario accepted")
+	}
+	if _, err := NewScenario(2).Add(System{}).Build(); err == nil {
+		t.Error("system without particles accepted")
+	}
+}
+
+/* particles sharing metadata share the relabeled copy, the metadata of the input is left alone */
+func TestScenarioLabels(t *testing.T) {
+	star := &nbody.ParticleMeta{Type: "star", Payload: "p"}
+	gas := &nbody.ParticleMeta{Type: "gas"}
+	particles := make([]nbody.Particle, 5)
+	for i, meta := range []*nbody.ParticleMeta{star, gas, star, nil, nil} {
+		particles[i].Mass = 1
+		particles[i].Meta = meta
+	}
+	particleArray, err := NewScenario(2).Add(System{Label: "A", Particles: particles}).Add(System{Particles: particles}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labeled := particleArray[:5]
+	if labeled[0].Meta != labeled[2].Meta || labeled[3].Meta != labeled[4].Meta || labeled[0].Meta == labeled[1].Meta {
+		t.Fatal("relabeled particles do not share metadata as before")
+	}
+	if *labeled[0].Meta != (nbody.ParticleMeta{Type: "star", Group: "A", Payload: "p"}) || labeled[3].Meta.Group != "A" || labeled[3].Meta.Type != "" {
+		t.Fatalf("relabeled as %+v and %+v", *labeled[0].Meta, *labeled[3].Meta)
+	}
+	if star.Group != "" || gas.Group != "" {
+		t.Fatal("the metadata of the input was changed")
+	}
+	if particleArray[5].Meta != star || particleArray[8].Meta != nil {
+		t.Fatal("a system without label changed the metadata")
+	}
+}
+
+func TestScenarioIDs(t *testing.T) {
+	loaded := make([]nbody.Particle, 3)
+	generated := make([]nbody.Particle, 4)
+	for i := range loaded {
+		loaded[i].Mass, loaded[i].ID = 1, uint64(2*i+1) /* 1, 3, 5 */
+	}
+	for i := range generated {
+		generated[i].Mass = 1
+	}
+	particleArray, err := NewScenario(2).Add(System{Particles: generated}).Add(System{Particles: loaded, KeepIDs: true}).
+		Add(System{Particles: generated}).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for i := range particleArray {
+		ids = append(ids, particleArray[i].ID)
+	}
+	expected := []uint64{0, 2, 4, 6, 1, 3, 5, 7, 8, 9, 10}
+	for i := range expected {
+		if ids[i] != expected[i] {
+			t.Fatalf("IDs %v, expected %v", ids, expected)
+		}
+	}
+
+	_, err = NewScenario(2).Add(System{Label: "a", Particles: loaded, KeepIDs: true}).Add(System{Label: "b", Particles: loaded, KeepIDs: true}).Build()
+	if err == nil || !strings.Contains(err.Error(), "id 1 is already used by system 0 (a)") {
+		t.Fatalf("repeated kept IDs: %v", err)
+	}
+}
+
+/* the encounter is set up in the center of mass frame */
+func TestTwoGalaxiesCenterOfMass(t *testing.T) {
+	for _, dim := range []int{2, 3} {
+		p := Params{N: 300, Radius: 1, Mass: 2, Seed: 5, Dim: dim, G: 1}
+		e := DefaultEncounter(p)
+		e.MassRatio = 0.5
+		s, err := TwoGalaxies(p, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		particleArray, err := s.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(particleArray) != 300 || particleArray[0].Meta.Group != "A" || particleArray[299].Meta.Group != "B" {
+			t.Fatalf("%dD: %d particles", dim, len(particleArray))
+		}
+		var mass, massB float64
+		var center, momentum [3]float64
+		for i := range particleArray {
+			q := &particleArray[i]
+			x, y, z := q.Position()
+			vx, vy, vz := q.Velocity()
+			mass += q.Mass
+			if q.Meta.Group == "B" {
+				massB += q.Mass
+			}
+			center = [3]float64{center[0] + q.Mass*x, center[1] + q.Mass*y, center[2] + q.Mass*z}
+			momentum = [3]float64{momentum[0] + q.Mass*vx, momentum[1] + q.Mass*vy, momentum[2] + q.Mass*vz}
+		}
+		if math.Abs(mass-2) > 1e-12 || math.Abs(massB/mass-1.0/3) > 0.01 {
+			t.Errorf("%dD: mass %g, of which %g in B", dim, mass, massB)
+		}
+		if !near(center, [3]float64{}) || math.Abs(momentum[0])+math.Abs(momentum[1])+math.Abs(momentum[2]) > 1e-9 {
+			t.Errorf("%dD: center of mass %v, momentum %v", dim, center, momentum)
+		}
+	}
+	if _, err := TwoGalaxies(Params{N: 10, Radius: 1, Mass: 1, Dim: 2, G: 1}, Encounter{Separation: 1, Pericenter: 2, MassRatio: 1}); err == nil {
+		t.Error("pericenter beyond the separation accepted")
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("stars.csv", "x,y,mass,id\n0,0,1,100\n1,0,1,101\n")
+	defaults := Params{Radius: 1, Mass: 1, Seed: 1, Dim: 2, G: 1}
+
+	/* the file is found next to the scenario, not in the working directory of the test */
+	path := write("ok.json", `{"systems": [{"model": "lattice", "n": 4}, {"label": "s", "file": "stars.csv", "offset": [5, 0, 0]},
+		{"file": "stars.csv", "renumber": true}]}`)
+	s, err := LoadScenario(path, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	particleArray, err := s.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(particleArray) != 8 || particleArray[4].ID != 100 || particleArray[5].ID != 101 || particleArray[6].ID != 4 || particleArray[7].ID != 5 {
+		t.Fatalf("read %d particles", len(particleArray))
+	}
+	if x, _, _ := particleArray[5].Position(); x != 6 {
+		t.Fatalf("file system placed at x = %g", x)
+	}
+
+	for _, c := range []struct {
+		content string
+		message string
+	}{
+		{`{"systems": [{"model": "lattice", "file": "stars.csv", "n": 4}]}`, "system 0 (): exactly one of model and file must be given"},
+		{`{"systems": [{"label": "x"}]}`, "system 0 (x): exactly one of model and file must be given"},
+		{`{"systems": [{"model": "lattice", "n": 4, "spin": 1}]}`, `unknown field "spin"`},
+		{`{"systems": [{"model": "lattice", "n": 4, "renumber": true}]}`, "renumber only applies to a file"},
+		{`{"systems": [{"model": "plummer", "n": 4}]}`, "plummer is not available in 2D"},
+		{`{"systems": [{"file": "missing.csv"}]}`, "missing.csv"},
+	} {
+		_, err := LoadScenario(write("bad.json", c.content), defaults)
+		if err == nil || !strings.Contains(err.Error(), c.message) || !strings.HasPrefix(err.Error(), filepath.Join(dir, "bad.json")+": ") {
+			t.Errorf("%s: got %v, expected %q", c.content, err, c.message)
+		}
+	}
+}
@@ -1,59 +1,382 @@
 package main
 
 import (
+	"proj3/diagnostics"
 	"proj3/execution"
+	"proj3/initial"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
 	"os"
 	"strconv"
 	"fmt"
 	"time"
 	"proj3/nbody"
+	"proj3/snapshot"
 )
 
 func main() {
+	defaults := nbody.DefaultConfig()
+	gravity := flag.Float64("G", 0, "gravitational constant (default: value of the unit system)")
+	timeStep := flag.Float64("dt", defaults.Dt, "time step")
+	theta := flag.Float64("theta", defaults.Theta, "Barnes Hut opening angle")
+	softening := flag.Float64("softening", defaults.Softening, "softening added to squared distances")
+	unitName := flag.String("units", defaults.Units.Name, "unit system: nbody, si, solar or galactic")
+	multipole := flag.Int("order", defaults.Multipole, "multipole order of accepted tree nodes: 1 (monopole) or 2 (quadrupole)")
+	macName := flag.String("mac", defaults.MAC.Name(), "acceptance criterion: barnes-hut, min-distance, bmax or relative")
+	alpha := flag.Float64("alpha", defaults.Alpha, "force accuracy of the relative acceptance criterion")
+	leafSize := flag.Int("leaf-size", defaults.LeafSize, "particles a tree leaf holds before it is split")
+	maxDepth := flag.Int("max-depth", defaults.MaxDepth, "tree depth at which leaves stop splitting and keep several particles (at most 32 in 2D and 21 in 3D with -builder morton)")
+	domain := flag.Float64("domain", defaults.Domain, "half width of the box particles may move in (0 for no limit)")
+	escapePolicy := flag.String("escape", defaults.EscapePolicy, "policy for escaping or NaN/Inf particles: drop, clamp or error")
+	treeBuilder := flag.String("builder", defaults.TreeBuilder, "tree construction: insert (per particle insertion) or morton (sorted by Morton key, depth capped at 32 in 2D and 21 in 3D)")
+	reorderEvery := flag.Int("reorder", defaults.ReorderEvery, "sort the particle array along a space filling curve every k steps (0 never)")
+	reorderCurve := flag.String("curve", defaults.ReorderCurve, "space filling curve of -reorder: morton or hilbert")
+	partition := flag.String("partition", defaults.Partition, "force work split of executor p: static (equal chunks of particles) or costzones (equal interaction counts in tree order)")
+	taskDepth := flag.Int("task-depth", defaults.TaskDepth, "depth down to which executor t splits the force walk into subtree tasks (0 chooses from the thread count)")
+	dim := flag.Int("dim", defaults.Dim, "spatial dimension: 2 (quadtree) or 3 (octree)")
+	initialConditions := flag.String("ic", "random", "initial conditions: random (uniform in the unit square or cube), circle, a generated model (see below), or a .csv or .json file")
+	scenarioPath := flag.String("scenario", "", "JSON file composing generated and loaded systems with offsets, velocities, rotations and labels, replaces -ic")
+	radius := flag.Float64("radius", 1, "scale radius of a generated model")
+	totalMass := flag.Float64("mass", 1, "total mass of a generated model")
+	seed := flag.Int64("seed", nbody.DefaultSeed, "seed of random and generated initial conditions")
+	columnMapping := flag.String("columns", "", "fields of -ic files read from other columns or keys, e.g. x=px,y=py,mass=m (CSV columns may be numbers)")
+	csvHeader := flag.Bool("header", true, "the first line of a CSV file given to -ic names the columns")
+	integratorName := flag.String("integrator", "euler", "time integrator: euler, leapfrog or verlet")
+	diagEvery := flag.Int("diagnostics", 0, "record energy and momentum diagnostics every k steps (0 disables)")
+	accuracy := flag.Bool("accuracy", false, "compare tree forces against direct summation on the initial particles and exit")
+	outputFormat := flag.String("format", "text", "particle output: text (positions with 6 decimals) or binary (versioned snapshots with full precision, velocities, masses and IDs)")
+	diagExact := flag.Bool("exact-potential", false, "also compute the O(N^2) exact potential energy in diagnostics")
+	checkpointEvery := flag.Int("checkpoint-every", 0, "write a checkpoint every k steps (0 never)")
+	checkpointPath := flag.String("checkpoint", "", "checkpoint file (default output/checkpoint_<executor>.ckpt)")
+	restartPath := flag.String("restart", "", "resume from a checkpoint, configuration, integrator and particles are taken from it")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <num_particles> <num_iterations> <executor> <num_threads>\n\nExecutors:\n%s\n\nModels of -ic:\n%s\n\nOptions:\n",
+			os.Args[0], execution.ExecutorUsage(), initial.GeneratorUsage())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	units, err := nbody.GetUnitSystem(*unitName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	mac, err := nbody.GetMAC(*macName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config := &nbody.SimulationConfig{G: units.G, Dt: *timeStep, Theta: *theta, Softening: *softening, Dim: *dim,
+		Multipole: *multipole, MAC: mac, Alpha: *alpha, LeafSize: *leafSize, MaxDepth: *maxDepth, Domain: *domain, EscapePolicy: *escapePolicy,
+		TreeBuilder: *treeBuilder, ReorderEvery: *reorderEvery, ReorderCurve: *reorderCurve,
+		Partition: *partition, TaskDepth: *taskDepth, Units: units}
+	if *gravity != 0 {
+		config.G = *gravity
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	integrator, err := nbody.GetIntegrator(*integratorName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	nParticles := 3000
     nIterations := 200
 
-	if len(os.Args) > 1 {
-        nParticles, _ = strconv.Atoi(os.Args[1])
+	if flag.NArg() > 0 {
+        nParticles, _ = strconv.Atoi(flag.Arg(0))
 	}
-    if len(os.Args) > 2 {
-        nIterations, _ = strconv.Atoi(os.Args[2])
+    if flag.NArg() > 1 {
+        nIterations, _ = strconv.Atoi(flag.Arg(1))
 	}
 
 	execType := "s"
-	if len(os.Args) > 3 {
-        execType = os.Args[3]
+	if flag.NArg() > 2 {
+        execType = flag.Arg(2)
 	}
 
 	nThreads := 1
-	if execType != "s" {
-		nThreads, _ = strconv.Atoi(os.Args[4])
+	if flag.NArg() > 3 {
+		nThreads, _ = strconv.Atoi(flag.Arg(3))
+	}
+	if nThreads < 1 {
+		nThreads = 1
 	}
 
-	particleArray := nbody.CreateParticleArray(nParticles)
+	rng := nbody.NewRand(*seed)
+	firstIter := 1
+	var particleArray []nbody.Particle
+	var restart snapshot.Checkpoint /* output files of the interrupted run, none unless restarting */
+	if *restartPath != "" {
+		checkpoint, err := snapshot.LoadCheckpoint(*restartPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		integrator, err = nbody.GetIntegrator(checkpoint.Integrator)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		restart = *checkpoint
+		config = checkpoint.Config
+		particleArray = checkpoint.Particles
+		rng = nbody.RestoreRand(checkpoint.Rand)
+		firstIter = checkpoint.Step + 1
+		nParticles = len(particleArray)
+		fmt.Printf("Restarting from %s after step %d\n", *restartPath, checkpoint.Step)
+	} else if *scenarioPath != "" {
+		scenario, err := initial.LoadScenario(*scenarioPath, initial.Params{Radius: *radius, Mass: *totalMass, Rand: rng, Dim: config.Dim, G: config.G})
+		if err == nil {
+			particleArray, err = scenario.Build()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		nParticles = len(particleArray)
+	} else {
+		switch *initialConditions {
+		case "random":
+			particleArray = nbody.CreateParticleArrayRand(nParticles, config.Dim, rng)
+		case "circle":
+			particleArray = nbody.GetCircle(nParticles)
+		default:
+			if generator, err := initial.GetGenerator(*initialConditions); err == nil {
+				particleArray, err = generator.Generate(initial.Params{N: nParticles, Radius: *radius, Mass: *totalMass, Rand: rng,
+					Dim: config.Dim, G: config.G})
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				break
+			}
+			columns, err := initial.ParseColumns(*columnMapping)
+			if err == nil {
+				particleArray, err = initial.Load(*initialConditions, initial.Options{Dim: config.Dim, Columns: columns, Header: *csvHeader})
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if len(particleArray) == 0 {
+				fmt.Fprintf(os.Stderr, "%s holds no particles\n", *initialConditions)
+				os.Exit(1)
+			}
+			nParticles = len(particleArray)
+		}
+	}
 
-	//Comment above line and uncomment below line for circular arrangement of particles
-	// particleArray := nbody.GetCircle(nParticles)
+	if *accuracy {
+		fmt.Println(diagnostics.MeasureAccuracy(particleArray, config, nThreads))
+		return
+	}
 
-    datafile, _ := os.Create("output/particles_" + execType + ".dat")
-	content := fmt.Sprintf("%d %d %d\n", nParticles, nIterations, 0)
-	_, _ = datafile.WriteString(content)
+	var observe execution.Observer
+	var recorder *diagnostics.Recorder
+	step := 0
+	diagnosticsPath := "output/diagnostics_" + execType + ".dat"
+	if *diagEvery > 0 {
+		datafile, err := reopenOutput(diagnosticsPath, restart.Diagnostics)
+		if datafile != nil {
+			recorder = diagnostics.AppendRecorder(datafile, *diagEvery, *diagExact)
+		} else if err == nil {
+			recorder, err = diagnostics.NewRecorder(diagnosticsPath, *diagEvery, *diagExact)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer recorder.Close()
+		observe = func(particleArray []nbody.Particle) {
+			if err := recorder.Observe(step, particleArray, config); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
 
+	executor, err := execution.NewExecutor(execType, config, integrator, observe, nThreads)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer executor.Close()
+
+	/* a restart continues the output files of the interrupted run, cut back to where the checkpoint was written */
+	var snapshots *snapshot.Writer
+	var outputPath string
+	var textHeader int64 /* length of the header line of text output, which a restart rewrites */
+	switch *outputFormat {
+	case "text":
+		outputPath = "output/particles_" + executor.Name() + ".dat"
+		frames := nIterations /* a continued file holds every frame, including those before the checkpoint */
+		if frames < firstIter-1 {
+			frames = firstIter - 1
+		}
+		header := fmt.Sprintf("%d %d %d\n", nParticles, frames, 0)
+		continued, err := continueText(outputPath, restart.Output, header)
+		if err == nil && !continued {
+			frames = nIterations - firstIter + 1
+			if frames < 0 {
+				frames = 0
+			}
+			header = fmt.Sprintf("%d %d %d\n", nParticles, frames, 0)
+			err = os.WriteFile(outputPath, []byte(header), 0644)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		textHeader = int64(len(header))
+	case "binary":
+		outputPath = "output/particles_" + executor.Name() + ".snap"
+		file, err := reopenOutput(outputPath, restart.Output)
+		if file != nil {
+			if snapshots, err = snapshot.Append(file, config); err != nil {
+				err = fmt.Errorf("%s: %w", outputPath, err)
+			}
+		} else if err == nil {
+			file, err = os.Create(outputPath)
+			if err == nil {
+				snapshots, err = snapshot.NewWriter(file, config)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer snapshots.Close()
+	default:
+		fmt.Fprintf(os.Stderr, "output format must be text or binary, got %q\n", *outputFormat)
+		os.Exit(1)
+	}
+
+	if *checkpointPath == "" {
+		*checkpointPath = "output/checkpoint_" + executor.Name() + ".ckpt"
+	}
+
+	arena := nbody.NewNodeArena()
+
+	fmt.Printf("Config: %s integrator=%s\n", config, integrator.Name())
     startTime := time.Now()
-    for iter := 1; iter <= nIterations; iter++ {
+    for iter := firstIter; iter <= nIterations; iter++ {
         fmt.Printf("Iteration: %d\n", iter)
-		min_limit, max_limit := nbody.WriteToFile(particleArray, execType)
-        root := nbody.InitRoot(min_limit, max_limit)
-
-		switch execType {
-		case "s":
-			execution.RunSequential(root, particleArray)
-		case "p":
-			execution.RunParallel(root, particleArray, nThreads)
-		case "w":
-			execution.RunWorkSteal(root, particleArray, nThreads)
+		step = iter - 1
+		var boundary nbody.BoundaryReport
+		particleArray, boundary, err = nbody.ApplyBoundary(particleArray, config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if boundary.NonFinite > 0 || boundary.Escaped > 0 {
+			fmt.Printf("Boundary (%s): %s\n", config.EscapePolicy, boundary)
+		}
+		if config.ReorderEvery > 0 && step % config.ReorderEvery == 0 {
+			nbody.ReorderParticles(particleArray, config, nThreads)
+		}
+		var min_limit, max_limit float64
+		if snapshots != nil {
+			if err := snapshots.WriteFrame(step, float64(step) * config.Dt, particleArray); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			min_limit, max_limit = nbody.GetBounds(particleArray)
+		} else {
+			min_limit, max_limit = nbody.WriteToFile(particleArray, executor.Name(), config.Dim)
+		}
+        root := arena.InitRoot(min_limit, max_limit, config)
+		executor.Step(root, particleArray)
+		if *checkpointEvery > 0 && iter % *checkpointEvery == 0 {
+			if snapshots != nil {
+				if err := snapshots.Flush(); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+			checkpoint := &snapshot.Checkpoint{Step: iter, Time: float64(iter) * config.Dt, Config: config,
+				Integrator: integrator.Name(), Rand: rng.State(), Output: outputFile(outputPath), Particles: particleArray}
+			checkpoint.Output.Size -= textHeader
+			if recorder != nil {
+				checkpoint.Diagnostics = outputFile(diagnosticsPath)
+			}
+			if err := snapshot.SaveCheckpoint(*checkpointPath, checkpoint); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
 		}
     }
     endTime := time.Since(startTime).Seconds()
+	times := executor.Stats()
     fmt.Printf("Total time: %.15f\n", endTime)
-}
\ No newline at end of file
+	fmt.Printf("Phase times: %s\n", &times)
+	if recorder != nil {
+		fmt.Println(recorder.Report())
+	}
+}
+
+/* reopen an output file the checkpoint refers to, cut back to its size then; nil when there is no such file */
+func reopenOutput(path string, output snapshot.OutputFile) (*os.File, error) {
+	if output.Path != path {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() < output.Size {
+		err = fmt.Errorf("%s is shorter than when the checkpoint was written", path)
+	}
+	if err == nil {
+		err = file.Truncate(output.Size)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+/*
+continue a text output file the checkpoint refers to under a new header line, with the frames written after the
+header cut back to their size then (output.Size counts them without the header); false when there is no such file
+*/
+func continueText(path string, output snapshot.OutputFile, header string) (bool, error) {
+	if output.Path != path {
+		return false, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	end := bytes.IndexByte(content, '\n')
+	if end < 0 || int64(len(content)-end-1) < output.Size {
+		return false, fmt.Errorf("%s is shorter than when the checkpoint was written", path)
+	}
+	frames := content[end+1 : int64(end+1)+output.Size]
+	return true, os.WriteFile(path, append([]byte(header), frames...), 0644)
+}
+
+/* path and current size of an output file for a checkpoint */
+func outputFile(path string) snapshot.OutputFile {
+	info, err := os.Stat(path)
+	if err != nil {
+		return snapshot.OutputFile{}
+	}
+	return snapshot.OutputFile{Path: path, Size: info.Size()}
+}
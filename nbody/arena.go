@@ -0,0 +1,68 @@
+package nbody
+
+import "sync"
+
+const arenaChunkSize = 4096
+
+/*
+pool of tree nodes reused from one iteration to the next. Nodes live in fixed size
+chunks so pointers stay valid while the arena grows, and allocation is locked so
+the parallel and work stealing inserts can split nodes concurrently.
+*/
+type NodeArena struct {
+	chunks [][]TreeNode
+	chunk  int /* chunk currently handed out from */
+	next   int /* first free node in that chunk */
+	mutex  sync.Mutex
+}
+
+func NewNodeArena() *NodeArena {
+	return &NodeArena{}
+}
+
+/* make every node available again, the memory and the bucket capacity of the nodes are kept */
+func (a *NodeArena) Reset() {
+	a.chunk = 0
+	a.next = 0
+}
+
+/* reset the arena and allocate the root of a new tree from it */
+func (a *NodeArena) InitRoot(min_limit float64, max_limit float64, cfg *SimulationConfig) *TreeNode {
+	a.Reset()
+	root := &a.alloc(1)[0]
+	root.arena = a
+	return initRoot(root, min_limit, max_limit, cfg)
+}
+
+/* node slots used since the last reset, including the unused ends of full chunks */
+func (a *NodeArena) Len() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.chunk*arenaChunkSize + a.next
+}
+
+/* n contiguous zeroed nodes, from the heap when there is no arena */
+func (a *NodeArena) alloc(n int) []TreeNode {
+	if a == nil {
+		return make([]TreeNode, n)
+	}
+
+	a.mutex.Lock()
+	if a.next+n > arenaChunkSize {
+		a.chunk++
+		a.next = 0
+	}
+	if a.chunk == len(a.chunks) {
+		a.chunks = append(a.chunks, make([]TreeNode, arenaChunkSize))
+	}
+	nodes := a.chunks[a.chunk][a.next : a.next+n]
+	a.next += n
+	a.mutex.Unlock()
+
+	for i := range nodes {
+		bucket := nodes[i].bucket[:0]
+		nodes[i] = TreeNode{}
+		nodes[i].bucket = bucket
+	}
+	return nodes
+}
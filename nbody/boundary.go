@@ -0,0 +1,100 @@
+package nbody
+
+import (
+	"fmt"
+	"math"
+)
+
+/* what happens to particles with non-finite state or outside the domain */
+const (
+	EscapeDrop  = "drop"  /* remove the particle from the simulation */
+	EscapeClamp = "clamp" /* move the particle back onto the domain boundary and stop its outward motion */
+	EscapeError = "error" /* stop the simulation */
+)
+
+/* particles the boundary check found and handled in one step */
+type BoundaryReport struct {
+	NonFinite int /* NaN or Inf in position or velocity */
+	Escaped   int /* finite, but outside the domain */
+}
+
+func (r BoundaryReport) String() string {
+	return fmt.Sprintf("%d particles with NaN/Inf state, %d particles outside the domain", r.NonFinite, r.Escaped)
+}
+
+/*
+check every particle before the tree is built and apply the escape policy of cfg.
+Dropped particles are removed, so the returned slice may be shorter than the input.
+*/
+func ApplyBoundary(particleArray []Particle, cfg *SimulationConfig) ([]Particle, BoundaryReport, error) {
+	var report BoundaryReport
+	kept := particleArray[:0]
+	for i := range particleArray {
+		p := &particleArray[i]
+		nonFinite := !p.isFinite()
+		escaped := !nonFinite && cfg.Domain > 0 && !p.insideDomain(cfg.Domain)
+		if !nonFinite && !escaped {
+			kept = append(kept, *p)
+			continue
+		}
+
+		if nonFinite {
+			report.NonFinite++
+		} else {
+			report.Escaped++
+		}
+		switch cfg.EscapePolicy {
+		case EscapeError:
+			if nonFinite {
+				return particleArray, report, fmt.Errorf("particle %d has non-finite state: position (%g, %g, %g), velocity (%g, %g, %g)",
+					p.ID, p.x, p.y, p.z, p.vx, p.vy, p.vz)
+			}
+			return particleArray, report, fmt.Errorf("particle %d at (%g, %g, %g) left the domain of half width %g",
+				p.ID, p.x, p.y, p.z, cfg.Domain)
+		case EscapeClamp:
+			p.clamp(cfg.Domain)
+			kept = append(kept, *p)
+		case EscapeDrop:
+		}
+	}
+	return kept, report, nil
+}
+
+func (p *Particle) isFinite() bool {
+	for _, v := range []float64{p.x, p.y, p.z, p.vx, p.vy, p.vz} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Particle) insideDomain(domain float64) bool {
+	return math.Abs(p.x) <= domain && math.Abs(p.y) <= domain && math.Abs(p.z) <= domain
+}
+
+/* NaN velocities are zeroed and positions are clamped to the domain, without a domain NaN or Inf coordinates go to the origin */
+func (p *Particle) clamp(domain float64) {
+	clampAxis(&p.x, &p.vx, domain)
+	clampAxis(&p.y, &p.vy, domain)
+	clampAxis(&p.z, &p.vz, domain)
+}
+
+func clampAxis(x *float64, v *float64, domain float64) {
+	if math.IsNaN(*v) || math.IsInf(*v, 0) {
+		*v = 0
+	}
+	if math.IsNaN(*x) || (domain <= 0 && math.IsInf(*x, 0)) {
+		*x = 0
+	}
+	if domain <= 0 {
+		return
+	}
+	if *x > domain {
+		*x = domain
+		*v = math.Min(*v, 0)
+	} else if *x < -domain {
+		*x = -domain
+		*v = math.Max(*v, 0)
+	}
+}
@@ -0,0 +1,64 @@
+package nbody
+
+import "sync/atomic"
+
+/* subtrees handed out per worker, more than one so that uneven subtrees even out */
+const subtreesPerWorker = 4
+
+/*
+upward pass shared by the workers of one step: every worker calls Run, claims subtrees below the top
+levels of the tree until none are left, and the worker finishing the last subtree completes the top levels.
+The zero value is ready to use, a pass must not be reused for another tree.
+*/
+type CenterOfMassPass struct {
+	next int32 /* index of the next unclaimed subtree */
+	done int32 /* subtrees whose centers of mass are complete */
+}
+
+func (c *CenterOfMassPass) Run(root *TreeNode, nWorkers int) {
+	top, subtrees := splitTopLevels(root, subtreesPerWorker*nWorkers)
+	for {
+		i := int(atomic.AddInt32(&c.next, 1)) - 1
+		if i >= len(subtrees) {
+			return
+		}
+		PopulateCenterOfMass(subtrees[i])
+		if int(atomic.AddInt32(&c.done, 1)) == len(subtrees) {
+			for j := len(top) - 1; j >= 0; j-- { /* children come after their parent, so walk back to the root */
+				calcCenterOfMass(&top[j])
+			}
+		}
+	}
+}
+
+/*
+open the tree level by level until there are at least target non-empty subtrees or only leaves are left.
+Returns the opened nodes in breadth first order and the subtrees below them. Every worker computes the
+same split, the tree is not modified.
+*/
+func splitTopLevels(root *TreeNode, target int) ([]*TreeNode, []*TreeNode) {
+	var top []*TreeNode
+	frontier := []*TreeNode{root}
+	for len(frontier) < target {
+		var next []*TreeNode
+		opened := false
+		for _, t := range frontier {
+			if isLeaf(t) {
+				next = append(next, t)
+				continue
+			}
+			opened = true
+			top = append(top, t)
+			for i := 0; i < t.childCount(); i++ {
+				if t.child[i] != nil && t.child[i].particleCount > 0 {
+					next = append(next, t.child[i])
+				}
+			}
+		}
+		frontier = next
+		if !opened {
+			break
+		}
+	}
+	return top, frontier
+}
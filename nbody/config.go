@@ -0,0 +1,147 @@
+package nbody
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/* unit system in which positions, velocities, masses and time are expressed */
+type UnitSystem struct {
+	Name        string
+	Description string
+	G           float64
+}
+
+var (
+	NBodyUnits    = UnitSystem{Name: "nbody", Description: "dimensionless N-body units", G: 1.0}
+	SIUnits       = UnitSystem{Name: "si", Description: "m, kg, s", G: 6.67430e-11}
+	SolarUnits    = UnitSystem{Name: "solar", Description: "AU, solar mass, year", G: 4.0 * math.Pi * math.Pi}
+	GalacticUnits = UnitSystem{Name: "galactic", Description: "kpc, solar mass, km/s", G: 4.30091e-6}
+)
+
+var unitSystems = []UnitSystem{NBodyUnits, SIUnits, SolarUnits, GalacticUnits}
+
+/* multipole order used for accepted tree nodes */
+const (
+	Monopole   = 1
+	Quadrupole = 2
+)
+
+/* physical constants and numerical parameters of a simulation run */
+type SimulationConfig struct {
+	G            float64 /* gravitational constant, in the chosen unit system */
+	Dt           float64 /* time step */
+	Theta        float64 /* opening angle for the Barnes Hut approximation */
+	Softening    float64 /* added to the squared distance to avoid singular forces */
+	Dim          int     /* 2 for a quadtree simulation, 3 for an octree simulation */
+	Multipole    int     /* Monopole or Quadrupole expansion of accepted nodes */
+	MAC          MAC     /* decides which nodes are accepted */
+	Alpha        float64 /* force accuracy of the relative acceptance criterion */
+	LeafSize     int     /* particles a leaf holds before it is split */
+	MaxDepth     int     /* tree depth at which leaves keep any number of particles instead of splitting */
+	Domain       float64 /* half width of the box particles may move in, 0 for no limit */
+	EscapePolicy string  /* EscapeDrop, EscapeClamp or EscapeError */
+	TreeBuilder  string  /* BuilderInsert or BuilderMorton, which caps MaxDepth at 32 levels in 2D and 21 in 3D */
+	ReorderEvery int     /* sort the particle array along a space filling curve every k steps, 0 never */
+	ReorderCurve string  /* CurveMorton or CurveHilbert */
+	Partition    string  /* PartitionStatic or PartitionCostZones */
+	TaskDepth    int     /* depth down to which the subtree task executor splits the force walk, 0 to choose from the thread count */
+	Units        UnitSystem
+}
+
+/* configuration matching the values the simulation always used */
+func DefaultConfig() *SimulationConfig {
+	return &SimulationConfig{
+		G:            NBodyUnits.G,
+		Dt:           0.01,
+		Theta:        0.5,
+		Softening:    1e-9,
+		Dim:          2,
+		Multipole:    Monopole,
+		MAC:          BarnesHutMAC{},
+		Alpha:        0.0025,
+		LeafSize:     1,
+		MaxDepth:     48,
+		Domain:       0,
+		EscapePolicy: EscapeError,
+		TreeBuilder:  BuilderInsert,
+		ReorderEvery: 0,
+		ReorderCurve: CurveMorton,
+		Partition:    PartitionStatic,
+		Units:        NBodyUnits,
+	}
+}
+
+/* look up a unit system by name */
+func GetUnitSystem(name string) (UnitSystem, error) {
+	names := make([]string, 0, len(unitSystems))
+	for _, units := range unitSystems {
+		if units.Name == strings.ToLower(name) {
+			return units, nil
+		}
+		names = append(names, units.Name)
+	}
+	return UnitSystem{}, fmt.Errorf("unknown unit system %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+/* check that the parameters describe a runnable simulation */
+func (cfg *SimulationConfig) Validate() error {
+	if cfg.G <= 0 {
+		return fmt.Errorf("gravitational constant must be positive, got %g", cfg.G)
+	}
+	if cfg.Dt <= 0 {
+		return fmt.Errorf("time step must be positive, got %g", cfg.Dt)
+	}
+	if cfg.Theta < 0 {
+		return fmt.Errorf("opening angle must not be negative, got %g", cfg.Theta)
+	}
+	if cfg.Softening < 0 {
+		return fmt.Errorf("softening must not be negative, got %g", cfg.Softening)
+	}
+	if cfg.Dim != 2 && cfg.Dim != 3 {
+		return fmt.Errorf("dimension must be 2 or 3, got %d", cfg.Dim)
+	}
+	if cfg.MAC == nil {
+		return fmt.Errorf("no acceptance criterion set")
+	}
+	if cfg.Alpha <= 0 {
+		return fmt.Errorf("relative acceptance tolerance must be positive, got %g", cfg.Alpha)
+	}
+	if cfg.LeafSize < 1 {
+		return fmt.Errorf("leaf size must be at least 1, got %d", cfg.LeafSize)
+	}
+	if cfg.MaxDepth < 1 {
+		return fmt.Errorf("maximum tree depth must be at least 1, got %d", cfg.MaxDepth)
+	}
+	if cfg.Domain < 0 {
+		return fmt.Errorf("domain half width must not be negative, got %g", cfg.Domain)
+	}
+	if cfg.EscapePolicy != EscapeDrop && cfg.EscapePolicy != EscapeClamp && cfg.EscapePolicy != EscapeError {
+		return fmt.Errorf("escape policy must be %s, %s or %s, got %q", EscapeDrop, EscapeClamp, EscapeError, cfg.EscapePolicy)
+	}
+	if cfg.ReorderEvery < 0 {
+		return fmt.Errorf("reorder interval must not be negative, got %d", cfg.ReorderEvery)
+	}
+	if cfg.ReorderCurve != CurveMorton && cfg.ReorderCurve != CurveHilbert {
+		return fmt.Errorf("reorder curve must be %s or %s, got %q", CurveMorton, CurveHilbert, cfg.ReorderCurve)
+	}
+	if cfg.Partition != PartitionStatic && cfg.Partition != PartitionCostZones {
+		return fmt.Errorf("partition must be %s or %s, got %q", PartitionStatic, PartitionCostZones, cfg.Partition)
+	}
+	if cfg.TaskDepth < 0 {
+		return fmt.Errorf("task depth must not be negative, got %d", cfg.TaskDepth)
+	}
+	if cfg.TreeBuilder != BuilderInsert && cfg.TreeBuilder != BuilderMorton {
+		return fmt.Errorf("tree builder must be %s or %s, got %q", BuilderInsert, BuilderMorton, cfg.TreeBuilder)
+	}
+	if cfg.Multipole != Monopole && cfg.Multipole != Quadrupole {
+		return fmt.Errorf("multipole order must be %d (monopole) or %d (quadrupole), got %d", Monopole, Quadrupole, cfg.Multipole)
+	}
+	return nil
+}
+
+func (cfg *SimulationConfig) String() string {
+	return fmt.Sprintf("dim=%d units=%s G=%g dt=%g theta=%g softening=%g multipole=%d mac=%s alpha=%g builder=%s", cfg.Dim, cfg.Units.Name,
+		cfg.G, cfg.Dt, cfg.Theta, cfg.Softening, cfg.Multipole, cfg.MAC.Name(), cfg.Alpha, cfg.TreeBuilder)
+}
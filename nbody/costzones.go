@@ -0,0 +1,47 @@
+package nbody
+
+/* how the parallel executor splits the force work between its goroutines */
+const (
+	PartitionStatic    = "static"    /* equal contiguous chunks of the particle array */
+	PartitionCostZones = "costzones" /* ranges of the particles in tree order with equal interaction counts of the last step */
+)
+
+/*
+cost zones partition: order receives the particles of the tree rooted at root in tree order, and bounds the
+nZones+1 indices into order such that order[bounds[k]:bounds[k+1]] has about 1/nZones of the total cost.
+The cost of a particle is its interaction count from the previous force computation, at least 1 so that the
+first step, without counts yet, splits by particle numbers. Both slices are reused when they are large enough.
+*/
+func CostZones(root *TreeNode, nZones int, order []*Particle, bounds []int) ([]*Particle, []int) {
+	order = order[:0]
+	total := 0
+	ForEachParticle(root, func(p *Particle) {
+		order = append(order, p)
+		total += particleCost(p)
+	})
+
+	if cap(bounds) < nZones+1 {
+		bounds = make([]int, nZones+1)
+	}
+	bounds = bounds[:nZones+1]
+	zone, cumulative := 1, 0
+	for i, p := range order {
+		for zone < nZones && cumulative*nZones >= zone*total { /* zone starts at the first particle past zone/nZones of the cost */
+			bounds[zone] = i
+			zone++
+		}
+		cumulative += particleCost(p)
+	}
+	for ; zone <= nZones; zone++ {
+		bounds[zone] = len(order)
+	}
+	bounds[0] = 0
+	return order, bounds
+}
+
+func particleCost(p *Particle) int {
+	if p.cost < 1 {
+		return 1
+	}
+	return p.cost
+}
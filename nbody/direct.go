@@ -0,0 +1,16 @@
+package nbody
+
+/* acceleration of particleArray[i] by summing over every other particle, with the same kernel as the tree walk */
+func ComputeDirectForce(particleArray []Particle, i int, cfg *SimulationConfig) {
+	p := &particleArray[i]
+	p.ax = 0
+	p.ay = 0
+	p.az = 0
+	p.pot = 0
+	p.cost = 0
+	for j := 0; j < len(particleArray); j++ {
+		if j != i {
+			calcForce(cfg, &p, &particleArray[j], particleArray[j].Mass)
+		}
+	}
+}
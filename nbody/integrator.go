@@ -0,0 +1,99 @@
+package nbody
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+time integration scheme applied to every particle once its acceleration for the
+current positions has been computed. Synchronize brings the velocity to the time
+of the current positions, Advance then moves the particle to the next step.
+*/
+type Integrator interface {
+	Name() string
+	Synchronize(p *Particle, cfg *SimulationConfig)
+	Advance(p *Particle, cfg *SimulationConfig)
+}
+
+/* explicit Euler step: kick velocity with the full acceleration, then drift */
+type EulerIntegrator struct{}
+
+/* leapfrog kick-drift-kick, velocities live on half steps between Advance and Synchronize */
+type LeapfrogIntegrator struct{}
+
+/* velocity Verlet, keeps the previous acceleration to average the two kicks */
+type VerletIntegrator struct{}
+
+var integrators = []Integrator{EulerIntegrator{}, LeapfrogIntegrator{}, VerletIntegrator{}}
+
+/* look up an integrator by name */
+func GetIntegrator(name string) (Integrator, error) {
+	names := make([]string, 0, len(integrators))
+	for _, integrator := range integrators {
+		if integrator.Name() == strings.ToLower(name) {
+			return integrator, nil
+		}
+		names = append(names, integrator.Name())
+	}
+	return nil, fmt.Errorf("unknown integrator %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+func (EulerIntegrator) Name() string {
+	return "euler"
+}
+
+func (EulerIntegrator) Synchronize(p *Particle, cfg *SimulationConfig) {
+}
+
+func (EulerIntegrator) Advance(p *Particle, cfg *SimulationConfig) {
+	kick(p, cfg.Dt)
+	UpdatePosition(p, cfg)
+	p.stepped = true
+}
+
+func (LeapfrogIntegrator) Name() string {
+	return "leapfrog"
+}
+
+/* closing half kick of the previous step */
+func (LeapfrogIntegrator) Synchronize(p *Particle, cfg *SimulationConfig) {
+	if p.stepped {
+		kick(p, cfg.Dt/2.0)
+	}
+}
+
+/* opening half kick followed by a full drift */
+func (LeapfrogIntegrator) Advance(p *Particle, cfg *SimulationConfig) {
+	kick(p, cfg.Dt/2.0)
+	UpdatePosition(p, cfg)
+	p.stepped = true
+}
+
+func (VerletIntegrator) Name() string {
+	return "verlet"
+}
+
+/* v(t) = v(t - dt) + (a(t - dt) + a(t)) dt / 2 */
+func (VerletIntegrator) Synchronize(p *Particle, cfg *SimulationConfig) {
+	if p.stepped {
+		p.vx += (p.prevAx + p.ax) * cfg.Dt / 2.0
+		p.vy += (p.prevAy + p.ay) * cfg.Dt / 2.0
+		p.vz += (p.prevAz + p.az) * cfg.Dt / 2.0
+	}
+}
+
+/* x(t + dt) = x(t) + v(t) dt + a(t) dt^2 / 2 */
+func (VerletIntegrator) Advance(p *Particle, cfg *SimulationConfig) {
+	p.x += p.vx*cfg.Dt + p.ax*cfg.Dt*cfg.Dt/2.0
+	p.y += p.vy*cfg.Dt + p.ay*cfg.Dt*cfg.Dt/2.0
+	p.z += p.vz*cfg.Dt + p.az*cfg.Dt*cfg.Dt/2.0
+	p.prevAx, p.prevAy, p.prevAz = p.ax, p.ay, p.az
+	p.stepped = true
+}
+
+func kick(p *Particle, h float64) {
+	p.vx += p.ax * h
+	p.vy += p.ay * h
+	p.vz += p.az * h
+}
@@ -0,0 +1,119 @@
+package nbody
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+/* multipole acceptance criterion: decides whether a node can stand in for its particles when computing the force on p */
+type MAC interface {
+	Name() string
+	Accept(node *TreeNode, p *Particle) bool
+}
+
+/* classic s / d < theta with d the distance to the center of mass */
+type BarnesHutMAC struct{}
+
+/* s / d_min < theta with d_min the distance to the nearest point of the cell, never accepts a cell containing p */
+type MinDistanceMAC struct{}
+
+/* bmax / d < theta with bmax the distance from the center of mass to the farthest corner of the cell */
+type BmaxMAC struct{}
+
+/* Gadget style G M s^2 / d^4 <= alpha |a_old|, falls back to Barnes Hut until p has an acceleration */
+type RelativeMAC struct{}
+
+var macs = []MAC{BarnesHutMAC{}, MinDistanceMAC{}, BmaxMAC{}, RelativeMAC{}}
+
+/* look up an acceptance criterion by name */
+func GetMAC(name string) (MAC, error) {
+	names := make([]string, 0, len(macs))
+	for _, mac := range macs {
+		if mac.Name() == strings.ToLower(name) {
+			return mac, nil
+		}
+		names = append(names, mac.Name())
+	}
+	return nil, fmt.Errorf("unknown acceptance criterion %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+func (BarnesHutMAC) Name() string {
+	return "barnes-hut"
+}
+
+func (BarnesHutMAC) Accept(node *TreeNode, p *Particle) bool {
+	d := math.Sqrt(distanceSqr(node.particle, p) + node.config.Softening)
+	s := math.Abs(node.lb - node.rb)
+	return s/d < node.config.Theta
+}
+
+func (MinDistanceMAC) Name() string {
+	return "min-distance"
+}
+
+func (MinDistanceMAC) Accept(node *TreeNode, p *Particle) bool {
+	dx := axisDistance(p.x, node.lb, node.rb)
+	dy := axisDistance(p.y, node.db, node.ub)
+	dz := axisDistance(p.z, node.nb, node.fb)
+	dMinSqr := dx*dx + dy*dy + dz*dz
+	if dMinSqr == 0 {
+		return false
+	}
+	s := math.Abs(node.lb - node.rb)
+	return s < node.config.Theta*math.Sqrt(dMinSqr)
+}
+
+func (BmaxMAC) Name() string {
+	return "bmax"
+}
+
+func (BmaxMAC) Accept(node *TreeNode, p *Particle) bool {
+	com := node.particle
+	bx := math.Max(math.Abs(com.x-node.lb), math.Abs(com.x-node.rb))
+	by := math.Max(math.Abs(com.y-node.db), math.Abs(com.y-node.ub))
+	bz := math.Max(math.Abs(com.z-node.nb), math.Abs(com.z-node.fb))
+	bmax := math.Sqrt(bx*bx + by*by + bz*bz)
+	d := math.Sqrt(distanceSqr(com, p) + node.config.Softening)
+	return bmax/d < node.config.Theta
+}
+
+func (RelativeMAC) Name() string {
+	return "relative"
+}
+
+func (RelativeMAC) Accept(node *TreeNode, p *Particle) bool {
+	if p.accOld == 0 {
+		return BarnesHutMAC{}.Accept(node, p)
+	}
+
+	/* open nodes that p lies in or next to, the expansion is poor there whatever the mass */
+	s := math.Abs(node.lb - node.rb)
+	margin := 0.1 * s
+	if p.x >= node.lb-margin && p.x <= node.rb+margin && p.y >= node.db-margin && p.y <= node.ub+margin &&
+		p.z >= node.nb-margin && p.z <= node.fb+margin {
+		return false
+	}
+
+	cfg := node.config
+	d2 := distanceSqr(node.particle, p) + cfg.Softening
+	return cfg.G*node.totalMass*s*s <= cfg.Alpha*p.accOld*d2*d2
+}
+
+func distanceSqr(p1 *Particle, p2 *Particle) float64 {
+	dx := p1.x - p2.x
+	dy := p1.y - p2.y
+	dz := p1.z - p2.z
+	return dx*dx + dy*dy + dz*dz
+}
+
+/* distance from v to the interval [lo, hi] along one axis */
+func axisDistance(v float64, lo float64, hi float64) float64 {
+	if v < lo {
+		return lo - v
+	}
+	if v > hi {
+		return v - hi
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package nbody
+
+import "strings"
+
+/* optional description of a particle, shared by all copies of it */
+type ParticleMeta struct {
+	Type    string /* kind of body, e.g. star, gas or dark matter */
+	Group   string /* system the body belongs to, e.g. the label of its galaxy */
+	Payload any    /* user data carried along unchanged, never written to output */
+}
+
+/* number the particles 0, 1, ... in array order, for arrays that were not created with IDs */
+func AssignIDs(particleArray []Particle, first uint64) {
+	for i := range particleArray {
+		particleArray[i].ID = first + uint64(i)
+	}
+}
+
+/* whether any particle carries metadata, the output files then get type and group columns */
+func hasMeta(particleArray []Particle) bool {
+	for i := range particleArray {
+		if particleArray[i].Meta != nil {
+			return true
+		}
+	}
+	return false
+}
+
+/* type and group of p as two whitespace free columns, - when unset */
+func metaColumns(p *Particle) string {
+	typeLabel, group := "", ""
+	if p.Meta != nil {
+		typeLabel, group = p.Meta.Type, p.Meta.Group
+	}
+	return column(typeLabel) + " " + column(group)
+}
+
+func column(label string) string {
+	if label == "" {
+		return "-"
+	}
+	return strings.Join(strings.Fields(label), "_")
+}
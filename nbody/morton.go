@@ -0,0 +1,240 @@
+package nbody
+
+import (
+	"sort"
+	"sync"
+)
+
+/* how executors build the tree every iteration */
+const (
+	BuilderInsert = "insert" /* recursive insertion with per node locks in the parallel executors */
+	BuilderMorton = "morton" /* sort particles by Morton key and build the tree from the sorted ranges without locks */
+)
+
+/* subtrees with at least this many particles are built on their own goroutine */
+const mortonParallelCutoff = 4096
+
+type mortonItem struct {
+	key uint64
+	p   *Particle
+}
+
+/* levels of the tree a 64 bit key can describe: 32 for a quadtree, 21 for an octree */
+func mortonLevels(dim int) int {
+	return 64 / dim
+}
+
+/*
+Morton key of p inside the root cell: the child index at every level, most significant first.
+It descends with the same midpoint comparisons as whichChildContains, so the key always agrees
+with the cells createNode builds.
+*/
+func mortonKey(root *TreeNode, p *Particle, levels int) uint64 {
+	dim := root.config.Dim
+	lb, rb, db, ub, nb, fb := root.lb, root.rb, root.db, root.ub, root.nb, root.fb
+	var key uint64
+	for level := 0; level < levels; level++ {
+		childNumber := 0
+		midX := (lb + rb) / 2.0
+		midY := (ub + db) / 2.0
+		midZ := (nb + fb) / 2.0
+		if p.x > midX {
+			childNumber |= 1
+			lb = midX
+		} else {
+			rb = midX
+		}
+		if p.y < midY {
+			childNumber |= 2
+			ub = midY
+		} else {
+			db = midY
+		}
+		if dim == 3 {
+			if p.z > midZ {
+				childNumber |= 4
+				nb = midZ
+			} else {
+				fb = midZ
+			}
+		}
+		key = key<<uint(dim) | uint64(childNumber)
+	}
+	return key
+}
+
+/* stable sort of chunks on separate goroutines, then merge neighbouring runs pairwise until one is left */
+func sortMortonItems(items []mortonItem, nThreads int) {
+	less := func(a []mortonItem) func(i, j int) bool {
+		return func(i, j int) bool { return a[i].key < a[j].key }
+	}
+	if nThreads <= 1 || len(items) < 2*nThreads {
+		sort.SliceStable(items, less(items))
+		return
+	}
+
+	chunkSize := (len(items) + nThreads - 1) / nThreads
+	var runs [][2]int
+	for start := 0; start < len(items); start += chunkSize {
+		end := start + chunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+		runs = append(runs, [2]int{start, end})
+	}
+
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		wg.Add(1)
+		go func(chunk []mortonItem) {
+			sort.SliceStable(chunk, less(chunk))
+			wg.Done()
+		}(items[run[0]:run[1]])
+	}
+	wg.Wait()
+
+	sorted := items
+	buffer := make([]mortonItem, len(items))
+	for len(runs) > 1 {
+		var merged [][2]int
+		src, dst := sorted, buffer
+		for i := 0; i < len(runs); i += 2 {
+			if i+1 == len(runs) {
+				copy(dst[runs[i][0]:runs[i][1]], src[runs[i][0]:runs[i][1]])
+				merged = append(merged, runs[i])
+				continue
+			}
+			left, right := runs[i], runs[i+1]
+			wg.Add(1)
+			go func() {
+				mergeMortonRuns(dst[left[0]:right[1]], src[left[0]:left[1]], src[right[0]:right[1]])
+				wg.Done()
+			}()
+			merged = append(merged, [2]int{left[0], right[1]})
+		}
+		wg.Wait()
+		sorted, buffer = buffer, sorted
+		runs = merged
+	}
+	if &sorted[0] != &items[0] {
+		copy(items, sorted) /* the last merge wrote into the scratch buffer */
+	}
+}
+
+func mergeMortonRuns(out []mortonItem, a []mortonItem, b []mortonItem) {
+	i, j, k := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		if b[j].key < a[i].key {
+			out[k] = b[j]
+			j++
+		} else {
+			out[k] = a[i]
+			i++
+		}
+		k++
+	}
+	k += copy(out[k:], a[i:])
+	copy(out[k:], b[j:])
+}
+
+/*
+build the whole tree below an empty root from Morton sorted particles, without node locks. Keys have 32 digits in
+2D and 21 in 3D, so the tree stops splitting at that depth even when cfg.MaxDepth is larger.
+*/
+func BuildMortonTree(root *TreeNode, particleArray []Particle, nThreads int) {
+	levels := mortonLevels(root.config.Dim)
+	if root.config.MaxDepth < levels {
+		levels = root.config.MaxDepth
+	}
+
+	items := make([]mortonItem, len(particleArray))
+	ParallelRange(len(particleArray), nThreads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			items[i] = mortonItem{key: mortonKey(root, &particleArray[i], levels), p: &particleArray[i]}
+		}
+	})
+	sortMortonItems(items, nThreads)
+
+	buildMortonRange(root, items, levels, nThreads > 1)
+}
+
+/* turn t into the subtree holding items, which share the first t.depth key digits */
+func buildMortonRange(t *TreeNode, items []mortonItem, levels int, parallel bool) {
+	for _, item := range items {
+		t.totalMass += item.p.Mass
+	}
+	t.particleCount = len(items)
+	if len(items) == 0 {
+		return
+	}
+
+	if len(items) <= t.config.LeafSize || t.depth >= levels { /* leaf, with a bucket when it holds several particles */
+		if len(items) == 1 {
+			t.particle = items[0].p
+		} else {
+			t.bucket = t.bucket[:0]
+			for _, item := range items {
+				t.bucket = append(t.bucket, item.p)
+			}
+		}
+		for _, item := range items {
+			item.p.Node = t
+		}
+		return
+	}
+
+	children := t.arena.alloc(t.childCount())
+	for i := 0; i < t.childCount(); i++ {
+		t.child[i] = createNode(&children[i], t, i)
+	}
+
+	dim := uint(t.config.Dim)
+	shift := uint(levels-t.depth-1) * dim
+	mask := uint64(1)<<dim - 1
+	var wg *sync.WaitGroup /* only created when a child is built on its own goroutine */
+	start := 0
+	for start < len(items) {
+		digit := (items[start].key >> shift) & mask
+		end := start + sort.Search(len(items)-start, func(i int) bool {
+			return (items[start+i].key>>shift)&mask != digit
+		})
+		if parallel && end-start >= mortonParallelCutoff {
+			if wg == nil {
+				wg = &sync.WaitGroup{}
+			}
+			wg.Add(1)
+			go func(child *TreeNode, childItems []mortonItem, wg *sync.WaitGroup) {
+				buildMortonRange(child, childItems, levels, parallel)
+				wg.Done()
+			}(t.child[digit], items[start:end], wg)
+		} else {
+			buildMortonRange(t.child[digit], items[start:end], levels, parallel)
+		}
+		start = end
+	}
+	if wg != nil {
+		wg.Wait()
+	}
+}
+
+/* run fn over contiguous chunks of [0, n) on nThreads goroutines and wait for all of them (1 runs sequentially) */
+func ParallelRange(n int, nThreads int, fn func(start int, end int)) {
+	if nThreads <= 1 {
+		fn(0, n)
+		return
+	}
+	perThread := (n + nThreads - 1) / nThreads
+	var wg sync.WaitGroup
+	for i := 0; i < nThreads; i++ {
+		start, end := GetStartAndEnd(i, n, perThread)
+		if start >= end {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			fn(start, end)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,88 @@
+package nbody
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+/* the Morton builder must produce the same cells, leaves and forces as inserting the particles one by one */
+func TestMortonTreeMatchesInsert(t *testing.T) {
+	for _, dim := range []int{2, 3} {
+		for _, leafSize := range []int{1, 8} {
+			for _, nThreads := range []int{1, 4} {
+				cfg := DefaultConfig()
+				cfg.Dim, cfg.LeafSize = dim, leafSize
+				cfg.MaxDepth = mortonLevels(dim)
+				initial := CreateParticleArray(2000, dim)
+				/* coincident particles end up in a bucket at the depth limit */
+				initial[1].x, initial[1].y, initial[1].z = initial[0].x, initial[0].y, initial[0].z
+
+				inserted := append([]Particle(nil), initial...)
+				sorted := append([]Particle(nil), initial...)
+				min_limit, max_limit := GetBounds(initial)
+				insertRoot := InitRoot(min_limit, max_limit, cfg)
+				for i := range inserted {
+					TreeInsert(insertRoot, &inserted[i], false)
+				}
+				mortonRoot := InitRoot(min_limit, max_limit, cfg)
+				BuildMortonTree(mortonRoot, sorted, nThreads)
+
+				compareTrees(t, insertRoot, mortonRoot)
+				PopulateCenterOfMass(insertRoot)
+				PopulateCenterOfMass(mortonRoot)
+				for i := range inserted {
+					ComputeForce(insertRoot, &inserted[i])
+					ComputeForce(mortonRoot, &sorted[i])
+					ax, ay, az := inserted[i].Acceleration()
+					bx, by, bz := sorted[i].Acceleration()
+					scale := math.Sqrt(ax*ax+ay*ay+az*az) + 1e-300
+					if d := math.Sqrt((ax-bx)*(ax-bx) + (ay-by)*(ay-by) + (az-bz)*(az-bz)); d > 1e-9*scale {
+						t.Fatalf("dim %d leaf size %d threads %d: acceleration of particle %d differs by %g", dim, leafSize, nThreads, i, d/scale)
+					}
+				}
+			}
+		}
+	}
+}
+
+func compareTrees(t *testing.T, a *TreeNode, b *TreeNode) {
+	t.Helper()
+	if a.Depth() != b.Depth() || a.ParticleCount() != b.ParticleCount() || a.IsLeaf() != b.IsLeaf() {
+		t.Fatalf("node at depth %d: insert has %d particles (leaf %v), morton has %d at depth %d (leaf %v)",
+			a.Depth(), a.ParticleCount(), a.IsLeaf(), b.ParticleCount(), b.Depth(), b.IsLeaf())
+	}
+	if math.Abs(a.totalMass-b.totalMass) > 1e-9*a.totalMass {
+		t.Fatalf("node at depth %d: mass %g against %g", a.Depth(), a.totalMass, b.totalMass)
+	}
+	if a.IsLeaf() {
+		if ia, ib := leafIDs(a), leafIDs(b); len(ia) != len(ib) {
+			t.Fatalf("leaf at depth %d: ids %v against %v", a.Depth(), ia, ib)
+		} else {
+			for i := range ia {
+				if ia[i] != ib[i] {
+					t.Fatalf("leaf at depth %d: ids %v against %v", a.Depth(), ia, ib)
+				}
+			}
+		}
+		return
+	}
+	ca, cb := a.Children(), b.Children()
+	for i := range ca {
+		if (ca[i] == nil) != (cb[i] == nil) {
+			t.Fatalf("node at depth %d: child %d exists in only one tree", a.Depth(), i)
+		}
+		if ca[i] != nil {
+			compareTrees(t, ca[i], cb[i])
+		}
+	}
+}
+
+func leafIDs(leaf *TreeNode) []uint64 {
+	var ids []uint64
+	ForEachParticle(leaf, func(p *Particle) {
+		ids = append(ids, p.ID)
+	})
+	sort.Slice(ids, func(i int, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
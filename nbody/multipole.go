@@ -0,0 +1,77 @@
+package nbody
+
+import "math"
+
+/* symmetric traceless tensor Q_ij = sum m (3 r_i r_j - r^2 delta_ij) */
+type quadrupole struct {
+	xx, yy, zz float64
+	xy, xz, yz float64
+}
+
+/* add the moment of a point mass m at offset (dx, dy, dz) from the expansion center */
+func (q *quadrupole) addPoint(m float64, dx float64, dy float64, dz float64) {
+	r2 := dx*dx + dy*dy + dz*dz
+	q.xx += m * (3.0*dx*dx - r2)
+	q.yy += m * (3.0*dy*dy - r2)
+	q.zz += m * (3.0*dz*dz - r2)
+	q.xy += m * 3.0 * dx * dy
+	q.xz += m * 3.0 * dx * dz
+	q.yz += m * 3.0 * dy * dz
+}
+
+func (q *quadrupole) add(other *quadrupole) {
+	q.xx += other.xx
+	q.yy += other.yy
+	q.zz += other.zz
+	q.xy += other.xy
+	q.xz += other.xz
+	q.yz += other.yz
+}
+
+/* combine the quadrupoles of the children, shifted to the center of mass of t (parallel axis theorem) */
+func calcQuadrupole(t *TreeNode) {
+	t.quad = quadrupole{}
+	if t.config.Multipole != Quadrupole || t.totalMass == 0 {
+		return
+	}
+	com := t.particle
+	for i := 0; i < t.childCount(); i++ {
+		temp := t.child[i]
+		if temp == nil || temp.particleCount == 0 {
+			continue
+		}
+		p := temp.particle
+		t.quad.addPoint(temp.totalMass, p.x-com.x, p.y-com.y, p.z-com.z)
+		if temp.particleCount > 1 {
+			t.quad.add(&temp.quad)
+		}
+	}
+	for _, p := range t.bucket {
+		t.quad.addPoint(p.Mass, p.x-com.x, p.y-com.y, p.z-com.z)
+	}
+}
+
+/* quadrupole correction to the acceleration and potential of p from an accepted node */
+func calcQuadrupoleForce(cfg *SimulationConfig, p *Particle, node *TreeNode) {
+	com := node.particle
+	q := &node.quad
+	rx := p.x - com.x
+	ry := p.y - com.y
+	rz := p.z - com.z
+	r2 := rx*rx + ry*ry + rz*rz + cfg.Softening
+	invR := 1.0 / math.Sqrt(r2)
+	invR2 := invR * invR
+	invR5 := invR2 * invR2 * invR
+
+	qrx := q.xx*rx + q.xy*ry + q.xz*rz
+	qry := q.xy*rx + q.yy*ry + q.yz*rz
+	qrz := q.xz*rx + q.yz*ry + q.zz*rz
+	rqr := rx*qrx + ry*qry + rz*qrz
+
+	/* a = G (Q r / r^5 - 5/2 (r Q r) r / r^7), phi = -G (r Q r) / (2 r^5) */
+	radial := 2.5 * rqr * invR2
+	p.ax += cfg.G * invR5 * (qrx - radial*rx)
+	p.ay += cfg.G * invR5 * (qry - radial*ry)
+	p.az += cfg.G * invR5 * (qrz - radial*rz)
+	p.pot -= 0.5 * cfg.G * rqr * invR5
+}
@@ -1,38 +1,82 @@
 package nbody
 
 import "math"
-import "math/rand"
 import "fmt"
 import "os"
 import "sync"
 
-const (
-    SOFTENING = 1e-9
-    dt = 0.01
-    theta = 0.5
-)
-
 type Particle struct {
-    x, y float64
-    vx, vy float64
+    x, y, z float64                /* z stays 0 in 2D runs */
+    vx, vy, vz float64
+    ax, ay, az float64             /* acceleration at the current positions */
+    prevAx, prevAy, prevAz float64 /* acceleration of the previous step, used by velocity Verlet */
+    accOld float64                 /* magnitude of the previous acceleration, used by the relative acceptance criterion */
+    pot float64            /* gravitational potential per unit mass from the tree walk */
+    stepped bool           /* set once the integrator advanced the particle */
+    cost int               /* interactions of the last force computation, used to balance the next one */
+    Mass float64
+    ID uint64              /* stable identifier, kept when particles are reordered, removed or merged */
+    Meta *ParticleMeta     /* optional type, group and payload, nil for plain particles */
     Node *TreeNode
 }
 
+func (p *Particle) Position() (float64, float64, float64) {
+    return p.x, p.y, p.z
+}
+
+func (p *Particle) Velocity() (float64, float64, float64) {
+    return p.vx, p.vy, p.vz
+}
+
+func (p *Particle) Acceleration() (float64, float64, float64) {
+    return p.ax, p.ay, p.az
+}
+
+func (p *Particle) SetPosition(x float64, y float64, z float64) {
+    p.x, p.y, p.z = x, y, z
+}
+
+func (p *Particle) SetVelocity(vx float64, vy float64, vz float64) {
+    p.vx, p.vy, p.vz = vx, vy, vz
+}
+
+/* potential per unit mass found by the last tree walk */
+func (p *Particle) Potential() float64 {
+    return p.pot
+}
+
+/* particles and nodes the last force computation on p interacted with */
+func (p *Particle) Cost() int {
+    return p.cost
+}
+
 type TreeNode struct
 {
     particle *Particle
 	totalMass float64
+    particleCount int
     lb, rb, db, ub float64
-    child [4]*TreeNode
+    nb, fb float64      /* near and far z bounds, both 0 for a quadtree */
+    child [8]*TreeNode  /* only the first 4 are used for a quadtree */
+    quad quadrupole     /* traceless quadrupole about the center of mass */
+    bucket []*Particle  /* particles of a leaf holding more than one, up to the leaf size or any number at the depth limit */
+    depth int
+    com Particle        /* storage for the center of mass of internal and bucket nodes */
     mutex sync.Mutex
-} 
+    config *SimulationConfig
+    arena *NodeArena    /* where the children come from, nil for heap allocated trees */
+}
 
-func createNode(parent *TreeNode, childNumber int) *TreeNode {
-    var newNode TreeNode
+/* initialize newNode, taken from the arena or the heap, as child childNumber of parent */
+func createNode(newNode *TreeNode, parent *TreeNode, childNumber int) *TreeNode {
     newNode.particle = nil
     newNode.totalMass = 0
+    newNode.particleCount = 0
+    newNode.config = parent.config
+    newNode.arena = parent.arena
+    newNode.depth = parent.depth + 1
 
-    switch childNumber {
+    switch childNumber & 3 {
     case 0: /* upper left square */
         newNode.lb = parent.lb
         newNode.rb = (parent.lb + parent.rb) / 2.0
@@ -55,23 +99,41 @@ func createNode(parent *TreeNode, childNumber int) *TreeNode {
         newNode.db = parent.db
     }
 
-    return &newNode
+    if childNumber & 4 == 0 { /* near half of an octree cell, or the whole quadtree cell */
+        newNode.nb = parent.nb
+        newNode.fb = (parent.nb + parent.fb) / 2.0
+    } else {    /* far half of an octree cell */
+        newNode.nb = (parent.nb + parent.fb) / 2.0
+        newNode.fb = parent.fb
+    }
+
+    return newNode
+}
+
+/* 4 children for a quadtree, 8 for an octree */
+func (t *TreeNode) childCount() int {
+    return 1 << t.config.Dim
 }
 
-/* check which child of parent node should hold the particle */
+/* check which child of parent node should hold the particle, particles on a split plane go to the lower numbered child
+   and particles outside the node (or with NaN coordinates) still get the nearest child instead of nil */
 func whichChildContains(t *TreeNode, p *Particle) *TreeNode {
-    for i := 0; i < 4; i++ {
-        temp := t.child[i]
-        if p.x >= temp.lb && p.x <= temp.rb && p.y >= temp.db && p.y <= temp.ub {
-            return t.child[i]
-        }
+    childNumber := 0
+    if p.x > (t.lb + t.rb) / 2.0 {
+        childNumber |= 1
+    }
+    if p.y < (t.ub + t.db) / 2.0 {
+        childNumber |= 2
+    }
+    if t.config.Dim == 3 && p.z > (t.nb + t.fb) / 2.0 {
+        childNumber |= 4
     }
 
-    return nil
+    return t.child[childNumber]
 }
 
 func isLeaf(t *TreeNode) bool {
-    for i := 0; i < 4; i++ {
+    for i := 0; i < t.childCount(); i++ {
         if t.child[i] != nil {
             return false
 		}
@@ -87,22 +149,44 @@ func TreeInsert(t *TreeNode, p *Particle, parallelFlag bool) {
     }
     if !isLeaf(t) {	/* internal node */
         temp = whichChildContains(t, p)
-        t.totalMass++
+        t.totalMass += p.Mass
+        t.particleCount++
         if parallelFlag {
             t.mutex.Unlock()
         }
         TreeInsert(temp, p, parallelFlag)
-    } else if t.particle != nil {		/* non-empty leaf node */
-        for i := 0; i < 4; i++ {
-            t.child[i] = createNode(t, i)
+    } else if t.particleCount > 0 && (t.particleCount < t.config.LeafSize || t.depth >= t.config.MaxDepth) {	/* leaf with room left, or at the depth limit */
+        if len(t.bucket) == 0 {
+            t.bucket = append(t.bucket[:0], t.particle)
+            t.particle = nil
+        }
+        t.bucket = append(t.bucket, p)
+        p.Node = t
+        t.totalMass += p.Mass
+        t.particleCount++
+        if parallelFlag {
+            t.mutex.Unlock()
+        }
+    } else if t.particleCount > 0 {		/* full leaf node */
+        children := t.arena.alloc(t.childCount())
+        for i := 0; i < t.childCount(); i++ {
+            t.child[i] = createNode(&children[i], t, i)
         }
 
-        parentParticle := t.particle
-        t.particle = nil
-        temp = whichChildContains(t, parentParticle) 	/* assign parent particle to one of the child nodes */
-        TreeInsert(temp, parentParticle, parallelFlag)
+        if len(t.bucket) == 0 {
+            parentParticle := t.particle
+            t.particle = nil
+            temp = whichChildContains(t, parentParticle) 	/* assign parent particle to one of the child nodes */
+            TreeInsert(temp, parentParticle, parallelFlag)
+        } else {
+            for _, q := range t.bucket {	/* distribute the bucket over the child nodes */
+                TreeInsert(whichChildContains(t, q), q, parallelFlag)
+            }
+            t.bucket = t.bucket[:0]
+        }
 
-        t.totalMass++
+        t.totalMass += p.Mass
+        t.particleCount++
         temp = whichChildContains(t, p) /* insert particle p */
         if parallelFlag {
             t.mutex.Unlock()    /* check if correct */
@@ -111,36 +195,60 @@ func TreeInsert(t *TreeNode, p *Particle, parallelFlag bool) {
     } else {		/* empty leaf node */
         t.particle = p
         p.Node = t
-        t.totalMass++
+        t.totalMass += p.Mass
+        t.particleCount++
         if parallelFlag {
             t.mutex.Unlock()
         }
     }
 }
 
+/* mass weighted center of mass of an internal node, falls back to the geometric center for massless particles */
 func calcCenterOfMass(node **TreeNode) {
     t := *node
-    if t.particle != nil || t.totalMass <= 1 { /* do not calculate for leaf nodes */
+    if t.particle != nil || t.particleCount <= 1 { /* do not calculate for leaf nodes */
         return
 	}
 
+    useCount := t.totalMass == 0
     x1 := 0.0
 	y1 := 0.0
-    for i := 0; i < 4; i++ {
+    z1 := 0.0
+    for i := 0; i < t.childCount(); i++ {
         temp := t.child[i]
-        if temp != nil && temp.totalMass != 0 {
+        if temp != nil && temp.particleCount != 0 {
             p := temp.particle
             mass := temp.totalMass
+            if useCount {
+                mass = float64(temp.particleCount)
+            }
             x1 += mass * p.x
             y1 += mass * p.y
+            z1 += mass * p.z
+        }
+    }
+    for _, p := range t.bucket {
+        mass := p.Mass
+        if useCount {
+            mass = 1
         }
+        x1 += mass * p.x
+        y1 += mass * p.y
+        z1 += mass * p.z
     }
 
 	mass := t.totalMass
-    var p Particle
+    if useCount {
+        mass = float64(t.particleCount)
+    }
+    p := &t.com
     p.x = x1 / mass
     p.y = y1 / mass
-    (*t).particle = &p
+    p.z = z1 / mass
+    p.Mass = t.totalMass
+    (*t).particle = p
+
+    calcQuadrupole(t)
 }
 
 /* calculate center of mass for all internal nodes in bottom up fashion */
@@ -148,56 +256,77 @@ func PopulateCenterOfMass(t *TreeNode) {
     if t == nil {
         return
 	}
-    for i := 0; i < 4; i++ {
+    for i := 0; i < t.childCount(); i++ {
         PopulateCenterOfMass(t.child[i])
 	}
 
     calcCenterOfMass(&t)
 }
 
-/* calculate force applied on particle1 by particle2 */
-func calcForce(particle1 **Particle, p2 *Particle, totalMass float64) {
+/* accumulate acceleration applied on particle1 by particle2, where totalMass is the mass of the source */
+func calcForce(cfg *SimulationConfig, particle1 **Particle, p2 *Particle, totalMass float64) {
     p1 := *particle1
     dx := p2.x - p1.x
     dy := p2.y - p1.y
-    distSqr := dx * dx + dy * dy + SOFTENING
+    dz := p2.z - p1.z
+    distSqr := dx * dx + dy * dy + dz * dz + cfg.Softening
     invDist := 1.0 / math.Sqrt(distSqr)
     invDist3 := invDist * invDist * invDist
 
     Fx := dx * invDist3
     Fy := dy * invDist3
-
-    massConstant := totalMass * dt
-    p1.vx += massConstant * Fx
-    p1.vy += massConstant * Fy
+    Fz := dz * invDist3
+
+    massConstant := cfg.G * totalMass
+    p1.ax += massConstant * Fx
+    p1.ay += massConstant * Fy
+    p1.az += massConstant * Fz
+    p1.pot -= massConstant * invDist
+    p1.cost++
 }
 
-/* check if center of mass can be used for force calculation */
+/* check if center of mass can be used for force calculation, according to the configured acceptance criterion */
 func isValid(t1 *TreeNode, p2 *Particle) bool {
-    p1 := t1.particle
-    dx := p1.x - p2.x
-    dy := p1.y - p2.y
-    distSqr := dx * dx + dy * dy + SOFTENING
-    d := math.Sqrt(distSqr)
-    s := math.Abs(t1.lb - t1.rb)
-    ratio := s / d
-    return ratio < theta
-}
-
-/* compute total force applied on particle */
-func ComputeNodeForce(curr *TreeNode, t *TreeNode) {
-    if curr == nil || curr.totalMass == 0 {
+    return t1.config.MAC.Accept(t1, p2)
+}
+
+/* compute acceleration of particle from scratch by walking the tree from root */
+func ComputeForce(root *TreeNode, p *Particle) {
+    p.accOld = math.Sqrt(p.ax * p.ax + p.ay * p.ay + p.az * p.az)
+    p.ax = 0
+    p.ay = 0
+    p.az = 0
+    p.pot = 0
+    p.cost = 0
+    ComputeNodeForce(root, p)
+}
+
+/* accumulate total force applied on particle */
+func ComputeNodeForce(curr *TreeNode, p *Particle) {
+    if curr == nil || curr.particleCount == 0 {
         return
 	}
 
-    if curr.totalMass == 1 {		/* in case of leaf node, calculate force between the 2 particles */
-        calcForce(&t.particle, curr.particle, 1)
+    if curr.particleCount == 1 {		/* in case of leaf node, calculate force between the 2 particles */
+        if curr.particle == p {      /* no self interaction */
+            return
+        }
+        calcForce(curr.config, &p, curr.particle, curr.particle.Mass)
     } else {
-        if isValid(curr, t.particle) {	/* check if center of mass can be used for force calculation */
-            calcForce(&t.particle, curr.particle, curr.totalMass)
+        if curr != p.Node && isValid(curr, p) {	/* check if center of mass can be used for force calculation */
+            calcForce(curr.config, &p, curr.particle, curr.totalMass)
+            if curr.config.Multipole == Quadrupole {
+                calcQuadrupoleForce(curr.config, p, curr)
+            }
+		} else if len(curr.bucket) > 0 {	/* direct summation over the particles of a bucket leaf */
+            for _, q := range curr.bucket {
+                if q != p {
+                    calcForce(curr.config, &p, q, q.Mass)
+                }
+            }
 		} else {	/* otherwise go down to children nodes */
-            for i := 0; i < 4; i++ {
-                ComputeNodeForce(curr.child[i], t)
+            for i := 0; i < curr.childCount(); i++ {
+                ComputeNodeForce(curr.child[i], p)
 			}
         }
     }
@@ -209,22 +338,27 @@ func TraverseTree(t *TreeNode, root *TreeNode) {
         return
 	}
 
-    if t.totalMass == 1 {
-        ComputeNodeForce(root, t) /* calculate force on particle if leaf node */
+    if len(t.bucket) > 0 {
+        for _, p := range t.bucket {
+            ComputeForce(root, p)
+        }
+    } else if t.particleCount == 1 {
+        ComputeForce(root, t.particle) /* calculate force on particle if leaf node */
     } else {
-        for i := 0; i < 4; i++ {
+        for i := 0; i < t.childCount(); i++ {
             temp := t.child[i]
-            if temp != nil && temp.totalMass != 0 {
+            if temp != nil && temp.particleCount != 0 {
                 TraverseTree(temp, root)
 			}
         }
     }
 }
 
-/* update position of particle after calculation of force */
-func UpdatePosition(p *Particle) {
-    p.x += p.vx * dt
-    p.y += p.vy * dt
+/* drift position of particle with its current velocity */
+func UpdatePosition(p *Particle, cfg *SimulationConfig) {
+    p.x += p.vx * cfg.Dt
+    p.y += p.vy * cfg.Dt
+    p.z += p.vz * cfg.Dt
 }
 
 func max(a float64, b float64) float64 {
@@ -241,33 +375,63 @@ func min(a float64, b float64) float64 {
     return b
 }
 
-/* random initialization of particles in (0, 1) */
-func randInit(data []Particle, n int) {
-    r := rand.New(rand.NewSource(99))
+/* random initialization of particles in (0, 1), the z coordinate is only drawn in 3D */
+func randInit(data []Particle, n int, dim int, r *Rand) {
     for i := 0; i < n; i++ {
 		data[i].x = r.Float64()
 		data[i].y = r.Float64()
 		data[i].vx = r.Float64()
 		data[i].vy = r.Float64()
+		if dim == 3 {
+			data[i].z = r.Float64()
+			data[i].vz = r.Float64()
+		}
+		data[i].Mass = 1.0
+		data[i].ID = uint64(i)
     }
 }
 
-func CreateParticleArray(nParticles int) []Particle {
+func CreateParticleArray(nParticles int, dim int) []Particle {
+    return CreateParticleArrayRand(nParticles, dim, NewRand(DefaultSeed))
+}
+
+/* random particles drawn from r, which can be saved afterwards to continue the same stream */
+func CreateParticleArrayRand(nParticles int, dim int, r *Rand) []Particle {
     particleArray := make([]Particle, nParticles)
-    randInit(particleArray, nParticles)
+    randInit(particleArray, nParticles, dim, r)
     return particleArray
 }
 
-/* write particle positions to file */
-func WriteToFile(particleArray []Particle, execType string) (float64, float64) {
+/* write particle positions and IDs to file, with a z column in 3D and type and group columns when any particle has metadata */
+func WriteToFile(particleArray []Particle, execType string, dim int) (float64, float64) {
+    datafile, _ := os.OpenFile("output/particles_" + execType + ".dat", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    defer datafile.Close()
+    withMeta := hasMeta(particleArray)
+    for i := 0; i < len(particleArray); i++ {
+        p := &particleArray[i]
+        var content string
+        if dim == 3 {
+            content = fmt.Sprintf("%f %f %f %d", p.x, p.y, p.z, p.ID)
+        } else {
+            content = fmt.Sprintf("%f %f %d", p.x, p.y, p.ID)
+        }
+        if withMeta {
+            content += " " + metaColumns(p)
+        }
+        _, _ = datafile.WriteString(content + " \n")
+    }
+
+    return GetBounds(particleArray)
+}
+
+/* bounds of the root cell enclosing all particles, padded by one unit on every side */
+func GetBounds(particleArray []Particle) (float64, float64) {
     max_limit := 0.0
 	min_limit := 0.0
-    datafile, _ := os.OpenFile("output/particles_" + execType + ".dat", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
     for i := 0; i < len(particleArray); i++ {
-        content := fmt.Sprintf("%f %f \n", particleArray[i].x, particleArray[i].y)
-        _, _ = datafile.WriteString(content)
-        max_limit = max(max_limit, max(particleArray[i].x, particleArray[i].y))
-        min_limit = min(min_limit, min(particleArray[i].x, particleArray[i].y))
+        p := &particleArray[i]
+        max_limit = max(max_limit, max(p.x, max(p.y, p.z)))
+        min_limit = min(min_limit, min(p.x, min(p.y, p.z)))
     }
     max_limit++
     min_limit--
@@ -275,20 +439,73 @@ func WriteToFile(particleArray []Particle, execType string) (float64, float64) {
     return min_limit, max_limit
 }
 
-/* initialize root of quad tree */
-func InitRoot(min_limit float64, max_limit float64) *TreeNode {
+/* initialize root of quad tree or octree, all nodes created below it share its configuration */
+func InitRoot(min_limit float64, max_limit float64, cfg *SimulationConfig) *TreeNode {
     var root TreeNode
-    for i := 0; i < 4; i++ {
+    return initRoot(&root, min_limit, max_limit, cfg)
+}
+
+func initRoot(root *TreeNode, min_limit float64, max_limit float64, cfg *SimulationConfig) *TreeNode {
+    for i := 0; i < len(root.child); i++ {
         root.child[i] = nil
     }
     root.particle = nil
     root.totalMass = 0
+    root.particleCount = 0
     root.lb = min_limit
     root.db = min_limit
     root.rb = max_limit
     root.ub = max_limit
+    if cfg.Dim == 3 {
+        root.nb = min_limit
+        root.fb = max_limit
+    }
+    root.config = cfg
 
-    return &root
+    return root
+}
+
+/* configuration the tree was built with */
+func (t *TreeNode) Config() *SimulationConfig {
+    return t.config
+}
+
+/* distance from the root, which has depth 0 */
+func (t *TreeNode) Depth() int {
+    return t.depth
+}
+
+/* particles stored in the subtree of t */
+func (t *TreeNode) ParticleCount() int {
+    return t.particleCount
+}
+
+/* child nodes of an internal node, all nil for a leaf */
+func (t *TreeNode) Children() []*TreeNode {
+    return t.child[:t.childCount()]
+}
+
+func (t *TreeNode) IsLeaf() bool {
+    return isLeaf(t)
+}
+
+/* call fn for every particle stored in the subtree of t, in the order TraverseTree visits them */
+func ForEachParticle(t *TreeNode, fn func(p *Particle)) {
+    if t == nil || t.particleCount == 0 {
+        return
+    }
+
+    if len(t.bucket) > 0 {
+        for _, p := range t.bucket {
+            fn(p)
+        }
+    } else if t.particleCount == 1 {
+        fn(t.particle)
+    } else {
+        for i := 0; i < t.childCount(); i++ {
+            ForEachParticle(t.child[i], fn)
+        }
+    }
 }
 
 /* get start and end index of particle array for goroutine */
@@ -311,6 +528,8 @@ func GetCircle(nParticles int) []Particle {
         p[i].y = radius * math.Sin(angle)
         p[i].vx = 0
         p[i].vy = 0
+        p[i].Mass = 1.0
+        p[i].ID = uint64(i)
     }
 	return p
 }
\ No newline at end of file
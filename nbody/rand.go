@@ -0,0 +1,62 @@
+package nbody
+
+import "math/rand"
+
+/* seed of the random initial conditions */
+const DefaultSeed = 99
+
+/* position of a Rand in its stream, enough to recreate it exactly */
+type RandState struct {
+	Seed  int64
+	Draws uint64 /* values taken from the source since it was seeded */
+}
+
+/*
+random number generator whose state can be saved in a checkpoint. The source of math/rand cannot be
+serialized, so the generator counts the values it hands out and is restored by seeding a new source and
+drawing the same number of values again.
+*/
+type Rand struct {
+	*rand.Rand
+	source *countingSource
+}
+
+type countingSource struct {
+	source rand.Source64
+	seed   int64
+	draws  uint64
+}
+
+func NewRand(seed int64) *Rand {
+	source := &countingSource{source: rand.NewSource(seed).(rand.Source64), seed: seed}
+	return &Rand{Rand: rand.New(source), source: source}
+}
+
+/* generator continuing the stream of the one state was taken from */
+func RestoreRand(state RandState) *Rand {
+	r := NewRand(state.Seed)
+	for r.source.draws < state.Draws {
+		r.source.Uint64()
+	}
+	return r
+}
+
+func (r *Rand) State() RandState {
+	return RandState{Seed: r.source.seed, Draws: r.source.draws}
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.source.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.source.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.source.Seed(seed)
+	s.seed = seed
+	s.draws = 0
+}
@@ -0,0 +1,100 @@
+package nbody
+
+/* space filling curves the particle array can be sorted along */
+const (
+	CurveMorton  = "morton"  /* Z order, the order in which the tree visits its leaves */
+	CurveHilbert = "hilbert" /* no jumps between distant cells, at the price of a costlier key */
+)
+
+/*
+sort particleArray in place along cfg.ReorderCurve through the bounding cell of the particles, so that
+particles close in space are close in memory and contiguous chunks of the array are compact regions of the
+tree. Node pointers are cleared, they referred to the tree built from the old order.
+*/
+func ReorderParticles(particleArray []Particle, cfg *SimulationConfig, nThreads int) {
+	if len(particleArray) < 2 {
+		return
+	}
+	min_limit, max_limit := GetBounds(particleArray)
+	var box TreeNode
+	initRoot(&box, min_limit, max_limit, cfg)
+	levels := mortonLevels(cfg.Dim)
+
+	items := make([]mortonItem, len(particleArray))
+	ParallelRange(len(particleArray), nThreads, func(start int, end int) {
+		for i := start; i < end; i++ {
+			p := &particleArray[i]
+			if cfg.ReorderCurve == CurveHilbert {
+				items[i] = mortonItem{key: hilbertKey(&box, p, levels), p: p}
+			} else {
+				items[i] = mortonItem{key: mortonKey(&box, p, levels), p: p}
+			}
+		}
+	})
+	sortMortonItems(items, nThreads)
+
+	sorted := make([]Particle, len(particleArray))
+	for i, item := range items {
+		sorted[i] = *item.p
+		sorted[i].Node = nil
+	}
+	copy(particleArray, sorted)
+}
+
+/* Hilbert index of p inside the cell of box with levels bits per axis, by Skilling's transpose algorithm */
+func hilbertKey(box *TreeNode, p *Particle, levels int) uint64 {
+	dim := box.config.Dim
+	var x [3]uint32
+	x[0] = quantize(p.x, box.lb, box.rb, levels)
+	x[1] = quantize(box.ub-p.y+box.db, box.db, box.ub, levels) /* y grows downwards in the tree, as in whichChildContains */
+	if dim == 3 {
+		x[2] = quantize(p.z, box.nb, box.fb, levels)
+	}
+
+	m := uint32(1) << uint(levels-1)
+	for q := m; q > 1; q >>= 1 { /* inverse undo of the excess work */
+		mask := q - 1
+		for i := 0; i < dim; i++ {
+			if x[i]&q != 0 {
+				x[0] ^= mask
+			} else {
+				t := (x[0] ^ x[i]) & mask
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	for i := 1; i < dim; i++ { /* Gray encode */
+		x[i] ^= x[i-1]
+	}
+	t := uint32(0)
+	for q := m; q > 1; q >>= 1 {
+		if x[dim-1]&q != 0 {
+			t ^= q - 1
+		}
+	}
+	for i := 0; i < dim; i++ {
+		x[i] ^= t
+	}
+
+	var key uint64
+	for bit := levels - 1; bit >= 0; bit-- {
+		for i := 0; i < dim; i++ {
+			key = key<<1 | uint64(x[i]>>uint(bit)&1)
+		}
+	}
+	return key
+}
+
+/* position of v in [lo, hi] as an integer with levels bits, values outside are clamped */
+func quantize(v float64, lo float64, hi float64, levels int) uint32 {
+	cells := float64(uint64(1) << uint(levels))
+	if hi <= lo || !(v > lo) {
+		return 0
+	}
+	q := (v - lo) / (hi - lo) * cells
+	if q >= cells {
+		return uint32(cells - 1)
+	}
+	return uint32(q)
+}
@@ -0,0 +1,30 @@
+package nbody
+
+/* every field of a particle the integration depends on, for checkpoints that must resume bit for bit */
+type ParticleState struct {
+	X, Y, Z                float64
+	Vx, Vy, Vz             float64
+	Ax, Ay, Az             float64
+	PrevAx, PrevAy, PrevAz float64
+	AccOld                 float64
+	Pot                    float64
+	Stepped                bool
+	Cost                   int
+}
+
+func (p *Particle) State() ParticleState {
+	return ParticleState{X: p.x, Y: p.y, Z: p.z, Vx: p.vx, Vy: p.vy, Vz: p.vz, Ax: p.ax, Ay: p.ay, Az: p.az,
+		PrevAx: p.prevAx, PrevAy: p.prevAy, PrevAz: p.prevAz, AccOld: p.accOld, Pot: p.pot, Stepped: p.stepped, Cost: p.cost}
+}
+
+/* restore the state of p, Mass, ID, Meta and Node are left alone */
+func (p *Particle) SetState(s ParticleState) {
+	p.x, p.y, p.z = s.X, s.Y, s.Z
+	p.vx, p.vy, p.vz = s.Vx, s.Vy, s.Vz
+	p.ax, p.ay, p.az = s.Ax, s.Ay, s.Az
+	p.prevAx, p.prevAy, p.prevAz = s.PrevAx, s.PrevAy, s.PrevAz
+	p.accOld = s.AccOld
+	p.pot = s.Pot
+	p.stepped = s.Stepped
+	p.cost = s.Cost
+}
@@ -9,7 +9,7 @@ if __name__ == "__main__":
     exec_type = sys.argv[3]
 
     fig, ax = plt.subplots(1, 1)
-    lines = np.loadtxt('output/particles_' + exec_type + '.dat', skiprows=1, dtype=float)
+    lines = np.loadtxt('output/particles_' + exec_type + '.dat', skiprows=1, usecols=(0, 1), dtype=float)
     max_limit = int(lines.max()) + 1
     min_limit = int(lines.min()) + 1
 
@@ -1,81 +1,113 @@
 package queue
 
-import (
-	"sync/atomic"
-	"unsafe"
-)
+import "sync/atomic"
 
-type StampedReference struct {
-	idx int
-	stamp int
+/*
+Chase-Lev work stealing deque. One owner goroutine pushes and pops at the bottom, any goroutine may steal
+from the top. The circular buffer doubles when it is full and keeps its size afterwards, so a deque reused
+across steps stops allocating once it has grown to the largest amount of work it held.
+Elements are pointers, so that slots can be read and written atomically without boxing.
+*/
+type Deque[T any] struct {
+	top    atomic.Int64
+	bottom atomic.Int64
+	buffer atomic.Pointer[ring[T]]
 }
 
-type DEQueue struct {
-	start int
-	end int
-	bottom int32
-	top unsafe.Pointer
+/* circular array indexed by the ever increasing top and bottom counters */
+type ring[T any] struct {
+	mask  int64
+	slots []atomic.Pointer[T]
 }
 
-func NewDEQueue(start int, end int) *DEQueue {
-	top := StampedReference{idx: start, stamp: 0}
-	queue := DEQueue{top: unsafe.Pointer(&top), bottom: int32(end), start: start, end: end}
-	return &queue
-}
+const minCapacity = 16
 
-func loadPointer(p *unsafe.Pointer) *StampedReference {
-	if p == nil {
-		return nil
+func newRing[T any](capacity int64) *ring[T] {
+	size := int64(minCapacity)
+	for size < capacity {
+		size *= 2
 	}
-	return (*StampedReference)(atomic.LoadPointer(p))
+	return &ring[T]{mask: size - 1, slots: make([]atomic.Pointer[T], size)}
 }
 
-func compareAndSwap(p *unsafe.Pointer, old *StampedReference, new *StampedReference) bool {
-	var oldUnsafe unsafe.Pointer
-	oldUnsafe = nil
-	if old != nil {
-		oldUnsafe = unsafe.Pointer(old)
+func (r *ring[T]) get(i int64) *T {
+	return r.slots[i&r.mask].Load()
+}
+
+func (r *ring[T]) put(i int64, item *T) {
+	r.slots[i&r.mask].Store(item)
+}
+
+/* copy of the live range [top, bottom) into a ring twice the size */
+func (r *ring[T]) grow(top int64, bottom int64) *ring[T] {
+	grown := newRing[T](2 * int64(len(r.slots)))
+	for i := top; i < bottom; i++ {
+		grown.put(i, r.get(i))
 	}
-	return atomic.CompareAndSwapPointer(p, oldUnsafe, unsafe.Pointer(new))
+	return grown
+}
+
+/* deque with room for capacity elements before it first grows */
+func NewDeque[T any](capacity int) *Deque[T] {
+	d := &Deque[T]{}
+	d.buffer.Store(newRing[T](int64(capacity)))
+	return d
 }
 
-func (dq *DEQueue) PopTop() int {
-	oldTop := loadPointer(&dq.top)
-	newTop := StampedReference{idx: oldTop.idx + 1, stamp: oldTop.stamp + 1}
-	
-	if int(dq.bottom) <= oldTop.idx {
-		return -1
+/* owner only: add item at the bottom */
+func (d *Deque[T]) PushBottom(item *T) {
+	b := d.bottom.Load()
+	t := d.top.Load()
+	r := d.buffer.Load()
+	if b-t >= int64(len(r.slots)) {
+		r = r.grow(t, b) /* thieves still reading the old ring see the same elements there */
+		d.buffer.Store(r)
 	}
+	r.put(b, item)
+	d.bottom.Store(b + 1)
+}
 
-	if compareAndSwap(&dq.top, oldTop, &newTop) {
-		return oldTop.idx
+/* owner only: remove the most recently pushed item, nil when the deque is empty */
+func (d *Deque[T]) PopBottom() *T {
+	b := d.bottom.Load() - 1
+	r := d.buffer.Load()
+	d.bottom.Store(b)
+	t := d.top.Load()
+	if t > b {
+		d.bottom.Store(b + 1)
+		return nil
 	}
 
-	return -1
+	item := r.get(b)
+	if t == b { /* last item, race the thieves for it */
+		if !d.top.CompareAndSwap(t, t+1) {
+			item = nil
+		}
+		d.bottom.Store(b + 1)
+	}
+	return item
 }
 
-func (dq *DEQueue) PopBottom() int {
-	bottom := int(dq.bottom)
-	if bottom == dq.start {
-		return -1
+/* any goroutine: remove the oldest item, nil when the deque is empty or another goroutine took it first */
+func (d *Deque[T]) Steal() *T {
+	t := d.top.Load()
+	b := d.bottom.Load()
+	if t >= b {
+		return nil
 	}
 
-	atomic.AddInt32(&dq.bottom, -1)
-	bottom--
-	particleIdx := bottom
-	oldTop := loadPointer(&dq.top)
-	newTop := StampedReference{idx: dq.end - 1, stamp: oldTop.stamp + 1}
+	item := d.buffer.Load().get(t)
+	if !d.top.CompareAndSwap(t, t+1) {
+		return nil
+	}
+	return item
+}
 
-	if bottom > oldTop.idx {
-		return particleIdx
-	} 
-	if bottom == oldTop.idx {
-		atomic.StoreInt32(&dq.bottom, int32(dq.start))
-		if compareAndSwap(&dq.top, oldTop, &newTop) {
-			return particleIdx
-		}
+/* number of items, only exact while no other goroutine uses the deque */
+func (d *Deque[T]) Len() int {
+	n := d.bottom.Load() - d.top.Load()
+	if n < 0 {
+		return 0
 	}
-	atomic.StorePointer(&dq.top, unsafe.Pointer(&newTop))
-	
-	return -1
-}
\ No newline at end of file
+	return int(n)
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func items(n int) []int {
+	values := make([]int, n)
+	for i := range values {
+		values[i] = i
+	}
+	return values
+}
+
+/* the owner pops in LIFO order, thieves take the oldest item, across growth of the ring */
+func TestOwnerOrder(t *testing.T) {
+	d := NewDeque[int](1)
+	if d.PopBottom() != nil || d.Steal() != nil {
+		t.Fatal("empty deque returned an item")
+	}
+	values := items(100) /* several times the minimum capacity */
+	for i := range values {
+		d.PushBottom(&values[i])
+	}
+	if d.Len() != len(values) {
+		t.Fatalf("length %d after %d pushes", d.Len(), len(values))
+	}
+	for i := 0; i < 10; i++ {
+		if item := d.Steal(); item == nil || *item != i {
+			t.Fatalf("steal %d returned %v", i, item)
+		}
+	}
+	for i := len(values) - 1; i >= 10; i-- {
+		if item := d.PopBottom(); item == nil || *item != i {
+			t.Fatalf("pop returned %v, expected %d", item, i)
+		}
+	}
+	if d.PopBottom() != nil || d.Steal() != nil || d.Len() != 0 {
+		t.Fatal("drained deque returned an item")
+	}
+
+	/* the deque is reusable after it was drained */
+	d.PushBottom(&values[0])
+	if item := d.PopBottom(); item != &values[0] {
+		t.Fatalf("pop after reuse returned %v", item)
+	}
+}
+
+/* an owner pushing and popping against several thieves, starting small so the ring grows under them */
+func TestConcurrentTakesEveryItemOnce(t *testing.T) {
+	const n = 20000
+	const thieves = 4
+	for round := 0; round < 5; round++ {
+		values := items(n)
+		taken := make([]atomic.Int32, n)
+		d := NewDeque[int](1)
+		var done atomic.Bool
+		var wg sync.WaitGroup
+		for i := 0; i < thieves; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					if item := d.Steal(); item != nil {
+						taken[*item].Add(1)
+					} else if done.Load() {
+						return
+					} else {
+						runtime.Gosched()
+					}
+				}
+			}()
+		}
+
+		/* bursts of pushes with a pop every few items, then drain */
+		for i := range values {
+			d.PushBottom(&values[i])
+			if i%3 == 0 {
+				if item := d.PopBottom(); item != nil {
+					taken[*item].Add(1)
+				}
+			}
+		}
+		for {
+			item := d.PopBottom()
+			if item == nil {
+				break
+			}
+			taken[*item].Add(1)
+		}
+		done.Store(true)
+		wg.Wait()
+		if d.Steal() != nil {
+			t.Fatal("items left after the owner drained the deque")
+		}
+
+		for i := range taken {
+			if count := taken[i].Load(); count != 1 {
+				t.Fatalf("round %d: item %d taken %d times", round, i, count)
+			}
+		}
+	}
+}
+
+/* owner and spinning thieves going for a deque that never holds more than one item: each is taken once */
+func TestLastItemRace(t *testing.T) {
+	const rounds = 20000
+	values := items(rounds)
+	taken := make([]atomic.Int32, rounds)
+	d := NewDeque[int](1)
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				if item := d.Steal(); item != nil {
+					taken[*item].Add(1)
+				} else {
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	for i := range values {
+		d.PushBottom(&values[i])
+		if item := d.PopBottom(); item != nil {
+			taken[*item].Add(1)
+		}
+		if i%16 == 0 {
+			runtime.Gosched() /* let the thieves run on a single processor too */
+		}
+	}
+	done.Store(true)
+	wg.Wait()
+	for i := range taken {
+		if count := taken[i].Load(); count != 1 {
+			t.Fatalf("item %d taken %d times", i, count)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"proj3/snapshot"
+)
+
+/* print a binary snapshot file as text: the header, then one line per particle of every frame */
+func main() {
+	headerOnly := flag.Bool("header", false, "print the header and the frame summaries only")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: snapdump [-header] <file.snap>")
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	reader, err := snapshot.NewReader(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("# version %d, %s\n", reader.Version(), reader.Config())
+
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("# step %d, time %g, %d particles\n", frame.Step, frame.Time, len(frame.Particles))
+		if *headerOnly {
+			continue
+		}
+		for i := range frame.Particles {
+			p := &frame.Particles[i]
+			x, y, z := p.Position()
+			vx, vy, vz := p.Velocity()
+			line := fmt.Sprintf("%d %.17g %.17g %.17g %.17g %.17g %.17g %.17g", p.ID, x, y, z, vx, vy, vz, p.Mass)
+			if p.Meta != nil {
+				line += fmt.Sprintf(" %q %q", p.Meta.Type, p.Meta.Group)
+			}
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"proj3/nbody"
+)
+
+/*
+A checkpoint holds everything a run needs to continue as if it had never stopped. It has its own magic and
+version and, unlike a frame, stores the full configuration and the complete internal state of every particle.
+
+	checkpoint: magic "NBCKPT", uint16 version, config, string integrator, uint64 step, float64 time,
+	            int64 seed, uint64 draws, output, output of the diagnostics, uint64 N, uint32 flags,
+	            N particle states
+	output:     string path, int64 size, since version 2
+	config:     uint8 dim, string units, float64 unit G, G, dt, theta, softening, alpha, uint8 multipole,
+	            string mac, int64 leaf size, max depth, float64 domain, string escape, builder,
+	            int64 reorder every, string curve, partition, int64 task depth
+	particle:   float64 x, y, z, vx, vy, vz, ax, ay, az, previous ax, ay, az, previous |a|, potential,
+	            mass, uint64 id, int64 cost, uint8 stepped, then string type, string group with FlagMeta
+*/
+const (
+	CheckpointMagic   = "NBCKPT"
+	CheckpointVersion = 2
+)
+
+var ErrBadCheckpoint = errors.New("not a checkpoint file")
+
+/* state of a run after Step steps */
+type Checkpoint struct {
+	Step        int
+	Time        float64
+	Config      *nbody.SimulationConfig
+	Integrator  string
+	Rand        nbody.RandState /* generator of the initial conditions, for runs that keep drawing from it */
+	Output      OutputFile      /* particle output of the run */
+	Diagnostics OutputFile
+	Particles   []nbody.Particle
+}
+
+/* an output file as it was when the checkpoint was written, so that a restart can continue it */
+type OutputFile struct {
+	Path string /* empty when the run wrote no such file, or for checkpoints of version 1 */
+	Size int64  /* bytes written, after the header line for text particle output, whose header a restart rewrites */
+}
+
+/* write c to path through a temporary file, so that a crash while writing keeps the previous checkpoint */
+func SaveCheckpoint(path string, c *Checkpoint) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := WriteCheckpoint(file, c); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	c, err := ReadCheckpoint(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func WriteCheckpoint(w io.Writer, c *Checkpoint) error {
+	sw := &Writer{w: bufio.NewWriterSize(w, 1<<16)}
+	cfg := c.Config
+	sw.write([]byte(CheckpointMagic))
+	sw.putUint16(CheckpointVersion)
+	sw.write([]byte{byte(cfg.Dim)})
+	sw.putString(cfg.Units.Name)
+	for _, v := range []float64{cfg.Units.G, cfg.G, cfg.Dt, cfg.Theta, cfg.Softening, cfg.Alpha} {
+		sw.putFloat64(v)
+	}
+	sw.write([]byte{byte(cfg.Multipole)})
+	sw.putString(cfg.MAC.Name())
+	sw.putUint64(uint64(cfg.LeafSize))
+	sw.putUint64(uint64(cfg.MaxDepth))
+	sw.putFloat64(cfg.Domain)
+	sw.putString(cfg.EscapePolicy)
+	sw.putString(cfg.TreeBuilder)
+	sw.putUint64(uint64(cfg.ReorderEvery))
+	sw.putString(cfg.ReorderCurve)
+	sw.putString(cfg.Partition)
+	sw.putUint64(uint64(cfg.TaskDepth))
+
+	sw.putString(c.Integrator)
+	sw.putUint64(uint64(c.Step))
+	sw.putFloat64(c.Time)
+	sw.putUint64(uint64(c.Rand.Seed))
+	sw.putUint64(c.Rand.Draws)
+	for _, output := range []OutputFile{c.Output, c.Diagnostics} {
+		sw.putString(output.Path)
+		sw.putUint64(uint64(output.Size))
+	}
+
+	flags := uint32(0)
+	for i := range c.Particles {
+		if c.Particles[i].Meta != nil {
+			flags |= FlagMeta
+			break
+		}
+	}
+	sw.putUint64(uint64(len(c.Particles)))
+	sw.putUint32(flags)
+	for i := range c.Particles {
+		p := &c.Particles[i]
+		s := p.State()
+		for _, v := range [15]float64{s.X, s.Y, s.Z, s.Vx, s.Vy, s.Vz, s.Ax, s.Ay, s.Az, s.PrevAx, s.PrevAy, s.PrevAz,
+			s.AccOld, s.Pot, p.Mass} {
+			sw.putFloat64(v)
+		}
+		sw.putUint64(p.ID)
+		sw.putUint64(uint64(s.Cost))
+		stepped := byte(0)
+		if s.Stepped {
+			stepped = 1
+		}
+		sw.write([]byte{stepped})
+		if flags&FlagMeta != 0 {
+			var meta nbody.ParticleMeta
+			if p.Meta != nil {
+				meta = *p.Meta
+			}
+			sw.putString(meta.Type)
+			sw.putString(meta.Group)
+		}
+	}
+	return sw.Flush()
+}
+
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	sr := &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+	magic := make([]byte, len(CheckpointMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != CheckpointMagic {
+		return nil, ErrBadCheckpoint
+	}
+	c, err := sr.checkpoint()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("checkpoint: %w", err)
+	}
+	return c, nil
+}
+
+/* the part of a checkpoint after the magic, io errors are returned as they are */
+func (sr *Reader) checkpoint() (*Checkpoint, error) {
+	version, err := sr.uint16()
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > CheckpointVersion {
+		return nil, fmt.Errorf("version %d is not supported (this reader knows versions 1 to %d)", version, CheckpointVersion)
+	}
+	sr.version = int(version)
+
+	cfg := nbody.DefaultConfig()
+	dim, err := sr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Dim = int(dim)
+	units, err := sr.string()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Units = nbody.UnitSystem{Name: units}
+	if system, err := nbody.GetUnitSystem(units); err == nil {
+		cfg.Units = system
+	}
+	for _, v := range []*float64{&cfg.Units.G, &cfg.G, &cfg.Dt, &cfg.Theta, &cfg.Softening, &cfg.Alpha} {
+		if *v, err = sr.float64(); err != nil {
+			return nil, err
+		}
+	}
+	multipole, err := sr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Multipole = int(multipole)
+	mac, err := sr.string()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MAC, err = nbody.GetMAC(mac); err != nil {
+		return nil, err
+	}
+	if err := sr.int(&cfg.LeafSize); err != nil {
+		return nil, err
+	}
+	if err := sr.int(&cfg.MaxDepth); err != nil {
+		return nil, err
+	}
+	if cfg.Domain, err = sr.float64(); err != nil {
+		return nil, err
+	}
+	if cfg.EscapePolicy, err = sr.string(); err != nil {
+		return nil, err
+	}
+	if cfg.TreeBuilder, err = sr.string(); err != nil {
+		return nil, err
+	}
+	if err := sr.int(&cfg.ReorderEvery); err != nil {
+		return nil, err
+	}
+	if cfg.ReorderCurve, err = sr.string(); err != nil {
+		return nil, err
+	}
+	if cfg.Partition, err = sr.string(); err != nil {
+		return nil, err
+	}
+	if err := sr.int(&cfg.TaskDepth); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{Config: cfg}
+	if c.Integrator, err = sr.string(); err != nil {
+		return nil, err
+	}
+	if err := sr.int(&c.Step); err != nil {
+		return nil, err
+	}
+	if c.Time, err = sr.float64(); err != nil {
+		return nil, err
+	}
+	seed, err := sr.uint64()
+	if err != nil {
+		return nil, err
+	}
+	c.Rand.Seed = int64(seed)
+	if c.Rand.Draws, err = sr.uint64(); err != nil {
+		return nil, err
+	}
+
+	if version >= 2 {
+		for _, output := range []*OutputFile{&c.Output, &c.Diagnostics} {
+			if output.Path, err = sr.string(); err != nil {
+				return nil, err
+			}
+			size, err := sr.uint64()
+			if err != nil {
+				return nil, err
+			}
+			output.Size = int64(size)
+		}
+	}
+
+	n, err := sr.uint64()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := sr.uint32()
+	if err != nil {
+		return nil, err
+	}
+	c.Particles = make([]nbody.Particle, 0, capacityHint(n))
+	for i := uint64(0); i < n; i++ {
+		c.Particles = append(c.Particles, nbody.Particle{})
+		p := &c.Particles[i]
+		var v [15]float64
+		for j := range v {
+			if v[j], err = sr.float64(); err != nil {
+				return nil, err
+			}
+		}
+		if p.ID, err = sr.uint64(); err != nil {
+			return nil, err
+		}
+		var cost int
+		if err := sr.int(&cost); err != nil {
+			return nil, err
+		}
+		stepped, err := sr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		p.SetState(nbody.ParticleState{X: v[0], Y: v[1], Z: v[2], Vx: v[3], Vy: v[4], Vz: v[5], Ax: v[6], Ay: v[7], Az: v[8],
+			PrevAx: v[9], PrevAy: v[10], PrevAz: v[11], AccOld: v[12], Pot: v[13], Stepped: stepped != 0, Cost: cost})
+		p.Mass = v[14]
+		if flags&FlagMeta != 0 {
+			var meta nbody.ParticleMeta
+			if meta.Type, err = sr.string(); err != nil {
+				return nil, err
+			}
+			if meta.Group, err = sr.string(); err != nil {
+				return nil, err
+			}
+			if meta.Type != "" || meta.Group != "" {
+				p.Meta = &meta
+			}
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,148 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"proj3/nbody"
+	"testing"
+)
+
+func testCheckpoint() *Checkpoint {
+	cfg := nbody.DefaultConfig()
+	cfg.Dim, cfg.LeafSize, cfg.TreeBuilder, cfg.ReorderEvery, cfg.Partition = 3, 8, nbody.BuilderMorton, 5, nbody.PartitionCostZones
+	cfg.Units = nbody.GalacticUnits
+	r := nbody.NewRand(7)
+	particleArray := nbody.CreateParticleArrayRand(30, 3, r)
+	for i := range particleArray {
+		s := particleArray[i].State()
+		s.Ax, s.PrevAy, s.AccOld, s.Pot, s.Stepped, s.Cost = r.NormFloat64(), r.NormFloat64(), r.Float64(), -r.Float64(), i%2 == 0, i
+		particleArray[i].SetState(s)
+	}
+	particleArray[2].Meta = &nbody.ParticleMeta{Type: "bulge", Group: "A"}
+	return &Checkpoint{Step: 12, Time: 0.12, Config: cfg, Integrator: "verlet", Rand: r.State(),
+		Output: OutputFile{Path: "output/particles_s.snap", Size: 12345}, Diagnostics: OutputFile{Path: "output/diagnostics_s.dat", Size: 99},
+		Particles: particleArray}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	c := testCheckpoint()
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCheckpoint(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Step != c.Step || read.Time != c.Time || read.Integrator != c.Integrator || read.Rand != c.Rand ||
+		read.Output != c.Output || read.Diagnostics != c.Diagnostics {
+		t.Fatalf("checkpoint read back as %+v", *read)
+	}
+	if read.Config.String() != c.Config.String() || read.Config.LeafSize != 8 || read.Config.ReorderEvery != 5 ||
+		read.Config.Partition != c.Config.Partition || read.Config.Units != c.Config.Units {
+		t.Fatalf("configuration read back as %s", read.Config)
+	}
+	if len(read.Particles) != len(c.Particles) {
+		t.Fatalf("%d particles read back, %d written", len(read.Particles), len(c.Particles))
+	}
+	for i := range read.Particles {
+		got, want := &read.Particles[i], &c.Particles[i]
+		if got.State() != want.State() || got.Mass != want.Mass || got.ID != want.ID {
+			t.Fatalf("particle %d read back as %+v, expected %+v", i, got.State(), want.State())
+		}
+		if (got.Meta == nil) != (want.Meta == nil) || got.Meta != nil && *got.Meta != *want.Meta {
+			t.Fatalf("particle %d: metadata %v, expected %v", i, got.Meta, want.Meta)
+		}
+	}
+}
+
+func TestDamagedCheckpoint(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCheckpoint(&buf, testCheckpoint()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := ReadCheckpoint(bytes.NewReader(data[:len(data)-5])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated checkpoint: %v", err)
+	}
+	if _, err := ReadCheckpoint(bytes.NewReader([]byte("NBSNAP"))); !errors.Is(err, ErrBadCheckpoint) {
+		t.Fatalf("snapshot read as a checkpoint: %v", err)
+	}
+
+	/* the particle count follows the diagnostics file size */
+	count := bytes.Index(data, []byte("output/diagnostics_s.dat")) + len("output/diagnostics_s.dat") + 8
+	if binary.LittleEndian.Uint64(data[count:]) != 30 {
+		t.Fatal("particle count not found")
+	}
+	huge := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(huge[count:], 1<<62)
+	if _, err := ReadCheckpoint(bytes.NewReader(huge)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("huge particle count: %v", err)
+	}
+}
+
+func TestAppendChecksHeader(t *testing.T) {
+	cfg := nbody.DefaultConfig()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, cfg)
+	w.WriteFrame(0, 0, nbody.CreateParticleArray(5, 2))
+	w.Flush()
+
+	file := &seekBuffer{data: append([]byte(nil), buf.Bytes()...)}
+	appended, err := Append(file, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appended.WriteFrame(1, cfg.Dt, nbody.CreateParticleArray(5, 2))
+	appended.Flush()
+	r, err := NewReader(bytes.NewReader(file.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := 0; step < 2; step++ {
+		if frame, err := r.ReadFrame(); err != nil || frame.Step != step {
+			t.Fatalf("frame %d read back as step %d, %v", step, frame.Step, err)
+		}
+	}
+
+	other := *cfg
+	other.Dt = 0.02
+	if _, err := Append(&seekBuffer{data: buf.Bytes()}, &other); err == nil {
+		t.Fatal("appended to a snapshot of another configuration")
+	}
+}
+
+/* in memory io.ReadWriteSeeker */
+type seekBuffer struct {
+	data   []byte
+	offset int
+}
+
+func (b *seekBuffer) Read(p []byte) (int, error) {
+	if b.offset >= len(b.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.offset:])
+	b.offset += n
+	return n, nil
+}
+
+func (b *seekBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data[:b.offset], p...)
+	b.offset += len(p)
+	return len(p), nil
+}
+
+func (b *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.offset = int(offset)
+	case io.SeekCurrent:
+		b.offset += int(offset)
+	case io.SeekEnd:
+		b.offset = len(b.data) + int(offset)
+	}
+	return int64(b.offset), nil
+}
@@ -0,0 +1,200 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"proj3/nbody"
+)
+
+/* reader of a snapshot file, frame by frame */
+type Reader struct {
+	r       *bufio.Reader
+	version int
+	config  *nbody.SimulationConfig
+	record  [recordSize]byte
+}
+
+/* read the header of a snapshot file from r */
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReaderSize(r, 1<<16)}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != Magic {
+		return nil, ErrBadMagic
+	}
+	version, err := sr.uint16()
+	if err != nil {
+		return nil, headerError(err)
+	}
+	if version < 1 || version > Version {
+		return nil, fmt.Errorf("snapshot version %d is not supported (this reader knows versions 1 to %d)", version, Version)
+	}
+	sr.version = int(version)
+
+	dim, err := sr.r.ReadByte()
+	if err != nil {
+		return nil, headerError(err)
+	}
+	units, err := sr.string()
+	if err != nil {
+		return nil, headerError(err)
+	}
+	var values [5]float64
+	for i := range values {
+		bits, err := sr.uint64()
+		if err != nil {
+			return nil, headerError(err)
+		}
+		values[i] = math.Float64frombits(bits)
+	}
+	multipole, err := sr.r.ReadByte()
+	if err != nil {
+		return nil, headerError(err)
+	}
+	mac, err := sr.string()
+	if err != nil {
+		return nil, headerError(err)
+	}
+	sr.config, err = configOf(int(dim), units, values[0], values[1], values[2], values[3], values[4], int(multipole), mac)
+	if err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+func (sr *Reader) Version() int {
+	return sr.version
+}
+
+/* configuration the snapshot was written with, fields the header does not store have their defaults */
+func (sr *Reader) Config() *nbody.SimulationConfig {
+	return sr.config
+}
+
+/* next frame, io.EOF after the last one */
+func (sr *Reader) ReadFrame() (Frame, error) {
+	n, err := sr.uint64()
+	if err == io.EOF {
+		return Frame{}, io.EOF
+	}
+	if err != nil {
+		return Frame{}, frameError(err)
+	}
+	step, err := sr.uint64()
+	if err != nil {
+		return Frame{}, frameError(err)
+	}
+	timeBits, err := sr.uint64()
+	if err != nil {
+		return Frame{}, frameError(err)
+	}
+	flags, err := sr.uint32()
+	if err != nil {
+		return Frame{}, frameError(err)
+	}
+
+	/* the count is not trusted to size the slice, a damaged file ends in a short read instead */
+	frame := Frame{Step: int(step), Time: math.Float64frombits(timeBits), Particles: make([]nbody.Particle, 0, capacityHint(n))}
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(sr.r, sr.record[:]); err != nil {
+			return Frame{}, frameError(err)
+		}
+		frame.Particles = append(frame.Particles, nbody.Particle{})
+		var v [7]float64
+		for j := range v {
+			v[j] = math.Float64frombits(binary.LittleEndian.Uint64(sr.record[8*j:]))
+		}
+		p := &frame.Particles[i]
+		p.SetPosition(v[0], v[1], v[2])
+		p.SetVelocity(v[3], v[4], v[5])
+		p.Mass = v[6]
+		p.ID = binary.LittleEndian.Uint64(sr.record[56:])
+
+		if flags&FlagMeta != 0 {
+			var meta nbody.ParticleMeta
+			if meta.Type, err = sr.string(); err != nil {
+				return Frame{}, frameError(err)
+			}
+			if meta.Group, err = sr.string(); err != nil {
+				return Frame{}, frameError(err)
+			}
+			if meta.Type != "" || meta.Group != "" {
+				p.Meta = &meta
+			}
+		}
+	}
+	return frame, nil
+}
+
+/* initial capacity for a particle count read from a file, large counts grow as records arrive */
+func capacityHint(n uint64) int {
+	const maxHint = 1 << 16
+	if n > maxHint {
+		return maxHint
+	}
+	return int(n)
+}
+
+func headerError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("snapshot header: %w", err)
+}
+
+func frameError(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("snapshot frame: %w", err)
+}
+
+func (sr *Reader) uint16() (uint16, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b[:]), nil
+}
+
+func (sr *Reader) uint32() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func (sr *Reader) uint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (sr *Reader) string() (string, error) {
+	n, err := sr.uint16()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (sr *Reader) float64() (float64, error) {
+	bits, err := sr.uint64()
+	return math.Float64frombits(bits), err
+}
+
+func (sr *Reader) int(v *int) error {
+	bits, err := sr.uint64()
+	*v = int(int64(bits))
+	return err
+}
@@ -0,0 +1,62 @@
+/*
+Package snapshot reads and writes particle snapshots in a versioned binary format.
+
+A file starts with a header and holds any number of frames. All numbers are little endian, strings are a
+uint16 byte count followed by the bytes.
+
+	header:   magic "NBSNAP", uint16 version, config
+	config:   uint8 dim, string units, float64 G, dt, theta, softening, alpha, uint8 multipole, string mac
+	frame:    uint64 N, uint64 step, float64 time, uint32 flags, N particle records
+	particle: float64 x, y, z, vx, vy, vz, mass, uint64 id,
+	          then string type, string group when the frame has FlagMeta
+
+Readers reject files with a newer version than they know, fields added by later versions go after the
+existing ones of a record and are announced by a flag.
+*/
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"proj3/nbody"
+)
+
+const (
+	Magic   = "NBSNAP"
+	Version = 1
+)
+
+/* bits of the frame flags */
+const (
+	FlagMeta = 1 << iota /* particle records carry type and group labels */
+)
+
+/* bytes of the fixed part of a particle record */
+const recordSize = 8 * 8
+
+var ErrBadMagic = errors.New("not a snapshot file")
+
+/* one snapshot of the particles */
+type Frame struct {
+	Step      int
+	Time      float64
+	Particles []nbody.Particle
+}
+
+/* the configuration stored in a header, fields it does not store keep their defaults */
+func configOf(dim int, units string, g float64, dt float64, theta float64, softening float64, alpha float64, multipole int,
+	mac string) (*nbody.SimulationConfig, error) {
+	cfg := nbody.DefaultConfig()
+	cfg.Dim, cfg.G, cfg.Dt, cfg.Theta, cfg.Softening, cfg.Alpha, cfg.Multipole = dim, g, dt, theta, softening, alpha, multipole
+	if system, err := nbody.GetUnitSystem(units); err == nil {
+		cfg.Units = system
+	} else {
+		cfg.Units = nbody.UnitSystem{Name: units, G: g}
+	}
+	criterion, err := nbody.GetMAC(mac)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot header: %w", err)
+	}
+	cfg.MAC = criterion
+	return cfg, nil
+}
@@ -0,0 +1,118 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"proj3/nbody"
+	"testing"
+)
+
+func testParticles(dim int) []nbody.Particle {
+	particleArray := nbody.CreateParticleArray(50, dim)
+	particleArray[3].ID = 1 << 40
+	particleArray[7].Meta = &nbody.ParticleMeta{Type: "star", Group: "disk A"}
+	return particleArray
+}
+
+func headerBytes(t *testing.T, cfg *nbody.SimulationConfig) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	cfg := nbody.DefaultConfig()
+	cfg.Dim, cfg.Dt, cfg.Theta = 3, 0.005, 0.7
+	particleArray := testParticles(3)
+	plain := nbody.CreateParticleArray(20, 3)
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrame(4, 0.02, particleArray); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteFrame(5, 0.025, plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Version() != Version || r.Config().Dim != 3 || r.Config().Dt != 0.005 || r.Config().Theta != 0.7 {
+		t.Fatalf("header read back as version %d, config %s", r.Version(), r.Config())
+	}
+	for _, expected := range []struct {
+		step      int
+		time      float64
+		particles []nbody.Particle
+	}{{4, 0.02, particleArray}, {5, 0.025, plain}} {
+		frame, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.Step != expected.step || frame.Time != expected.time || len(frame.Particles) != len(expected.particles) {
+			t.Fatalf("frame of step %d at %g with %d particles, expected step %d", frame.Step, frame.Time, len(frame.Particles), expected.step)
+		}
+		for i := range frame.Particles {
+			got, want := &frame.Particles[i], &expected.particles[i]
+			gx, gy, gz := got.Position()
+			wx, wy, wz := want.Position()
+			gvx, gvy, gvz := got.Velocity()
+			wvx, wvy, wvz := want.Velocity()
+			if gx != wx || gy != wy || gz != wz || gvx != wvx || gvy != wvy || gvz != wvz || got.Mass != want.Mass || got.ID != want.ID {
+				t.Fatalf("step %d particle %d read back differently", frame.Step, i)
+			}
+			if (got.Meta == nil) != (want.Meta == nil) || got.Meta != nil && (got.Meta.Type != want.Meta.Type || got.Meta.Group != want.Meta.Group) {
+				t.Fatalf("step %d particle %d: metadata %v, expected %v", frame.Step, i, got.Meta, want.Meta)
+			}
+		}
+	}
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last frame, got %v", err)
+	}
+}
+
+func TestDamagedFiles(t *testing.T) {
+	cfg := nbody.DefaultConfig()
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, cfg)
+	w.WriteFrame(0, 0, testParticles(2))
+	w.Flush()
+	data := buf.Bytes()
+	header := len(headerBytes(t, cfg))
+
+	if _, err := NewReader(bytes.NewReader([]byte("NBSNAQ..."))); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("bad magic: %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(data[:header-3])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated header: %v", err)
+	}
+
+	truncated, _ := NewReader(bytes.NewReader(data[:len(data)-10]))
+	if _, err := truncated.ReadFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated frame: %v", err)
+	}
+
+	/* a huge particle count must end in an error, not in an allocation of that size */
+	huge := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(huge[header:], 1<<62)
+	r, _ := NewReader(bytes.NewReader(huge))
+	if _, err := r.ReadFrame(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("huge particle count: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"proj3/nbody"
+)
+
+/* buffered writer of a snapshot file, Flush or Close must be called after the last frame */
+type Writer struct {
+	w      *bufio.Writer
+	closer io.Closer
+	record [recordSize]byte
+	err    error
+}
+
+/* start a snapshot file on w with the header for cfg, w is closed by Close if it is an io.Closer */
+func NewWriter(w io.Writer, cfg *nbody.SimulationConfig) (*Writer, error) {
+	sw := &Writer{w: bufio.NewWriterSize(w, 1<<16)}
+	if closer, ok := w.(io.Closer); ok {
+		sw.closer = closer
+	}
+
+	sw.write([]byte(Magic))
+	sw.putUint16(Version)
+	sw.write([]byte{byte(cfg.Dim)})
+	sw.putString(cfg.Units.Name)
+	for _, v := range []float64{cfg.G, cfg.Dt, cfg.Theta, cfg.Softening, cfg.Alpha} {
+		sw.putUint64(math.Float64bits(v))
+	}
+	sw.write([]byte{byte(cfg.Multipole)})
+	sw.putString(cfg.MAC.Name())
+	return sw, sw.err
+}
+
+/*
+continue the snapshot file f after the frames it holds, once its header is the one NewWriter would write for
+cfg. f is closed by Close if it is an io.Closer.
+*/
+func Append(f io.ReadWriteSeeker, cfg *nbody.SimulationConfig) (*Writer, error) {
+	var expected bytes.Buffer
+	headerWriter, err := NewWriter(&expected, cfg)
+	if err == nil {
+		err = headerWriter.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, expected.Len())
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, headerError(err)
+	}
+	if !bytes.HasPrefix(header, []byte(Magic)) {
+		return nil, ErrBadMagic
+	}
+	if !bytes.Equal(header, expected.Bytes()) {
+		return nil, fmt.Errorf("snapshot header was written for another version or configuration")
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	sw := &Writer{w: bufio.NewWriterSize(f, 1<<16)}
+	if closer, ok := f.(io.Closer); ok {
+		sw.closer = closer
+	}
+	return sw, nil
+}
+
+/* append a frame with the particles after step steps at the given simulation time */
+func (sw *Writer) WriteFrame(step int, time float64, particleArray []nbody.Particle) error {
+	flags := uint32(0)
+	for i := range particleArray {
+		if particleArray[i].Meta != nil {
+			flags |= FlagMeta
+			break
+		}
+	}
+
+	sw.putUint64(uint64(len(particleArray)))
+	sw.putUint64(uint64(step))
+	sw.putUint64(math.Float64bits(time))
+	sw.putUint32(flags)
+	for i := range particleArray {
+		p := &particleArray[i]
+		x, y, z := p.Position()
+		vx, vy, vz := p.Velocity()
+		for j, v := range [7]float64{x, y, z, vx, vy, vz, p.Mass} {
+			binary.LittleEndian.PutUint64(sw.record[8*j:], math.Float64bits(v))
+		}
+		binary.LittleEndian.PutUint64(sw.record[56:], p.ID)
+		sw.write(sw.record[:])
+
+		if flags&FlagMeta != 0 {
+			var meta nbody.ParticleMeta
+			if p.Meta != nil {
+				meta = *p.Meta
+			}
+			sw.putString(meta.Type)
+			sw.putString(meta.Group)
+		}
+	}
+	return sw.err
+}
+
+func (sw *Writer) Flush() error {
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.err
+}
+
+/* flush and close the underlying writer */
+func (sw *Writer) Close() error {
+	err := sw.Flush()
+	if sw.closer != nil {
+		if closeErr := sw.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+/* the first error sticks, later writes are skipped */
+func (sw *Writer) write(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *Writer) putUint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	sw.write(b[:])
+}
+
+func (sw *Writer) putUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	sw.write(b[:])
+}
+
+func (sw *Writer) putUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	sw.write(b[:])
+}
+
+func (sw *Writer) putString(s string) {
+	if len(s) > math.MaxUint16 {
+		if sw.err == nil {
+			sw.err = fmt.Errorf("snapshot: string of %d bytes is too long", len(s))
+		}
+		return
+	}
+	sw.putUint16(uint16(len(s)))
+	sw.write([]byte(s))
+}
+
+func (sw *Writer) putFloat64(v float64) {
+	sw.putUint64(math.Float64bits(v))
+}